@@ -58,7 +58,7 @@ func (app *Application) Start(ctx context.Context) <-chan struct{} {
 // listen blocks to hear n messages
 func (app *Application) listen(ctx context.Context, n int, mt domain.MessageType) <-chan struct{} {
 	var (
-		l, cleanup = app.relayer.Subscribe(mt)
+		l, cleanup = app.relayer.Subscribe(ctx, mt)
 		taking     = utils.ReadN(ctx.Done(), l, n)
 		done       = make(chan struct{})
 	)
@@ -0,0 +1,236 @@
+// Package codec implements a versioned binary framing of domain.Message.
+//
+// a version 3 frame is laid out big-endian as:
+//
+//	version        uint8
+//	type           int32
+//	timestamp      int64
+//	correlation    uint16
+//	correlation id [correlation]byte
+//	id size        uint8
+//	id             [id size]byte
+//	sequence       uint64
+//	length         uint32
+//	payload        [length]byte
+//	checksum       uint32 (crc32 IEEE of every preceding byte of the frame)
+//
+// a version 2 frame has no id or sequence and a version 1 frame has no
+// correlation id either.  Decode reads every version and Encode writes
+// CurrentVersion.
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"github.com/mstreet3/message-relayer/domain"
+)
+
+const (
+	Version1 uint8 = 1
+	Version2 uint8 = 2
+	Version3 uint8 = 3
+
+	// CurrentVersion is the version written by Encode.
+	CurrentVersion = Version3
+
+	// MaxPayloadSize bounds the payload of a single frame.
+	MaxPayloadSize = 16 << 20
+
+	// MaxCorrelationIDSize bounds the correlation id of a single frame.
+	MaxCorrelationIDSize = math.MaxUint16
+
+	// MaxIDSize bounds the message id of a single frame.
+	MaxIDSize = math.MaxUint8
+
+	prefixSize      = 1 + 4 + 8
+	correlationSize = 2
+	idSize          = 1
+	sequenceSize    = 8
+	lengthSize      = 4
+	checksumSize    = 4
+
+	// headerSize is the size of a version 3 header without a correlation
+	// id or message id.
+	headerSize = prefixSize + correlationSize + idSize + sequenceSize + lengthSize
+)
+
+var (
+	ErrUnsupportedVersion    = errors.New("codec: unsupported frame version")
+	ErrChecksumMismatch      = errors.New("codec: frame checksum mismatch")
+	ErrPayloadTooLarge       = errors.New("codec: payload exceeds max size")
+	ErrCorrelationIDTooLarge = errors.New("codec: correlation id exceeds max size")
+	ErrIDTooLarge            = errors.New("codec: message id exceeds max size")
+	ErrTrailingBytes         = errors.New("codec: trailing bytes after frame")
+)
+
+// Encode writes msg to w as a single frame.
+func Encode(w io.Writer, msg domain.Message) error {
+	frame, err := Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(frame)
+	return err
+}
+
+// Decode reads a single frame from r.  it returns io.EOF if r is exhausted
+// before the frame starts and io.ErrUnexpectedEOF if it ends mid frame.
+func Decode(r io.Reader) (*domain.Message, error) {
+	prefix := make([]byte, prefixSize)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+
+	v := prefix[0]
+	if v < Version1 || v > Version3 {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+
+	var (
+		mt  = domain.MessageType(int32(binary.BigEndian.Uint32(prefix[1:5])))
+		ts  = int64(binary.BigEndian.Uint64(prefix[5:13]))
+		crc = crc32.NewIEEE()
+		cid []byte
+		id  []byte
+		seq uint64
+	)
+
+	_, _ = crc.Write(prefix)
+
+	if v >= Version2 {
+		b, err := read(r, crc, correlationSize)
+		if err != nil {
+			return nil, err
+		}
+		if cid, err = read(r, crc, int(binary.BigEndian.Uint16(b))); err != nil {
+			return nil, err
+		}
+	}
+
+	if v >= Version3 {
+		b, err := read(r, crc, idSize)
+		if err != nil {
+			return nil, err
+		}
+		if id, err = read(r, crc, int(b[0])); err != nil {
+			return nil, err
+		}
+		if b, err = read(r, crc, sequenceSize); err != nil {
+			return nil, err
+		}
+		seq = binary.BigEndian.Uint64(b)
+	}
+
+	b, err := read(r, crc, lengthSize)
+	if err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(b)
+	if length > MaxPayloadSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, length)
+	}
+
+	payload, err := read(r, crc, int(length))
+	if err != nil {
+		return nil, err
+	}
+
+	checksum := make([]byte, checksumSize)
+	if _, err := io.ReadFull(r, checksum); err != nil {
+		return nil, unexpected(err)
+	}
+
+	if binary.BigEndian.Uint32(checksum) != crc.Sum32() {
+		return nil, ErrChecksumMismatch
+	}
+
+	msg := domain.NewMessage(mt, payload)
+	msg.Timestamp = ts
+	msg.CorrelationID = string(cid)
+	msg.ID = string(id)
+	msg.Sequence = seq
+
+	return &msg, nil
+}
+
+// Marshal returns msg encoded as a single frame.
+func Marshal(msg domain.Message) ([]byte, error) {
+	if len(msg.Data) > MaxPayloadSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, len(msg.Data))
+	}
+
+	if len(msg.CorrelationID) > MaxCorrelationIDSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrCorrelationIDTooLarge, len(msg.CorrelationID))
+	}
+
+	if len(msg.ID) > MaxIDSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrIDTooLarge, len(msg.ID))
+	}
+
+	mt := int64(msg.Type())
+	if mt < math.MinInt32 || mt > math.MaxInt32 {
+		return nil, fmt.Errorf("codec: message type %d does not fit in a frame", mt)
+	}
+
+	frame := make([]byte, prefixSize, headerSize+len(msg.CorrelationID)+len(msg.ID)+len(msg.Data)+checksumSize)
+	frame[0] = CurrentVersion
+	binary.BigEndian.PutUint32(frame[1:5], uint32(int32(mt)))
+	binary.BigEndian.PutUint64(frame[5:13], uint64(msg.Timestamp))
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(msg.CorrelationID)))
+	frame = append(frame, msg.CorrelationID...)
+	frame = append(frame, uint8(len(msg.ID)))
+	frame = append(frame, msg.ID...)
+	frame = binary.BigEndian.AppendUint64(frame, msg.Sequence)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(msg.Data)))
+	frame = append(frame, msg.Data...)
+
+	return binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame)), nil
+}
+
+// Unmarshal decodes b, which must hold exactly one frame.
+func Unmarshal(b []byte) (*domain.Message, error) {
+	r := bytes.NewReader(b)
+
+	msg, err := Decode(r)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+
+	if r.Len() > 0 {
+		return nil, ErrTrailingBytes
+	}
+
+	return msg, nil
+}
+
+// read reads the next n bytes of a started frame and adds them to crc.  it
+// returns nil for n of zero.
+func read(r io.Reader, crc io.Writer, n int) ([]byte, error) {
+	if n == 0 {
+		return nil, nil
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, unexpected(err)
+	}
+	_, _ = crc.Write(b)
+
+	return b, nil
+}
+
+// unexpected converts io.EOF into io.ErrUnexpectedEOF for reads that
+// happen once a frame has started.
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,231 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mstreet3/message-relayer/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func newMessage(mt domain.MessageType, data []byte, ts int64) domain.Message {
+	msg := domain.NewMessage(mt, data)
+	msg.Timestamp = ts
+	return msg
+}
+
+func withCorrelationID(msg domain.Message, id string) domain.Message {
+	msg.CorrelationID = id
+	return msg
+}
+
+func withID(msg domain.Message, id string, seq uint64) domain.Message {
+	msg.ID = id
+	msg.Sequence = seq
+	return msg
+}
+
+// marshalV2 encodes msg as a version 2 frame.
+func marshalV2(msg domain.Message) []byte {
+	frame := []byte{Version2}
+	frame = binary.BigEndian.AppendUint32(frame, uint32(int32(msg.Type())))
+	frame = binary.BigEndian.AppendUint64(frame, uint64(msg.Timestamp))
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(msg.CorrelationID)))
+	frame = append(frame, msg.CorrelationID...)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(msg.Data)))
+	frame = append(frame, msg.Data...)
+	return binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame))
+}
+
+// marshalV1 encodes msg as a version 1 frame.
+func marshalV1(msg domain.Message) []byte {
+	frame := []byte{Version1}
+	frame = binary.BigEndian.AppendUint32(frame, uint32(int32(msg.Type())))
+	frame = binary.BigEndian.AppendUint64(frame, uint64(msg.Timestamp))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(msg.Data)))
+	frame = append(frame, msg.Data...)
+	return binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame))
+}
+
+func Test_round_trip(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  domain.Message
+	}{
+		{"empty payload", newMessage(domain.StartNewRound, nil, 0)},
+		{"payload", newMessage(domain.ReceivedAnswer, []byte("answer"), 1663000000000000000)},
+		{"negative values", newMessage(domain.MessageType(-7), []byte{0}, -1)},
+		{"correlation id", withCorrelationID(newMessage(domain.ReceivedAnswer, []byte("answer"), 7), "round-42")},
+		{"id and sequence", withID(newMessage(domain.StartNewRound, []byte("round"), 7), "8b7c0e9e-3f0b-4c55-9a53-1d8f3f7a2f10", 42)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := Marshal(tt.msg)
+			require.NoError(t, err)
+			require.Len(t, frame, headerSize+len(tt.msg.CorrelationID)+len(tt.msg.ID)+len(tt.msg.Data)+checksumSize)
+
+			got, err := Unmarshal(frame)
+			require.NoError(t, err)
+			require.Equal(t, tt.msg, *got)
+		})
+	}
+}
+
+func Test_Decode_reads_consecutive_frames(t *testing.T) {
+	var (
+		buf  bytes.Buffer
+		msgs = []domain.Message{
+			newMessage(domain.StartNewRound, []byte("first"), 1),
+			newMessage(domain.ReceivedAnswer, []byte("second"), 2),
+		}
+	)
+
+	for _, msg := range msgs {
+		require.NoError(t, Encode(&buf, msg))
+	}
+
+	for _, want := range msgs {
+		got, err := Decode(&buf)
+		require.NoError(t, err)
+		require.Equal(t, want, *got)
+	}
+
+	_, err := Decode(&buf)
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func Test_Decode_reads_version1_frames(t *testing.T) {
+	want := newMessage(domain.ReceivedAnswer, []byte("answer"), 42)
+
+	got, err := Unmarshal(marshalV1(want))
+	require.NoError(t, err)
+	require.Equal(t, want, *got)
+
+	_, err = Unmarshal(marshalV1(want)[:prefixSize+2])
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func Test_Decode_reads_version2_frames(t *testing.T) {
+	want := withCorrelationID(newMessage(domain.ReceivedAnswer, []byte("answer"), 42), "round-42")
+
+	got, err := Unmarshal(marshalV2(want))
+	require.NoError(t, err)
+	require.Equal(t, want, *got)
+}
+
+func Test_Marshal_rejects_oversized_id(t *testing.T) {
+	msg := withID(domain.NewMessage(domain.StartNewRound, nil), strings.Repeat("x", MaxIDSize+1), 1)
+
+	_, err := Marshal(msg)
+	require.ErrorIs(t, err, ErrIDTooLarge)
+}
+
+func Test_Marshal_rejects_oversized_correlation_id(t *testing.T) {
+	msg := withCorrelationID(domain.NewMessage(domain.StartNewRound, nil), strings.Repeat("x", MaxCorrelationIDSize+1))
+
+	_, err := Marshal(msg)
+	require.ErrorIs(t, err, ErrCorrelationIDTooLarge)
+}
+
+func Test_Decode_rejects_corrupt_frames(t *testing.T) {
+	frame, err := Marshal(newMessage(domain.StartNewRound, []byte("payload"), 42))
+	require.NoError(t, err)
+
+	corrupt := func(f func(b []byte) []byte) []byte {
+		b := make([]byte, len(frame))
+		copy(b, frame)
+		return f(b)
+	}
+
+	tests := []struct {
+		name  string
+		frame []byte
+		want  error
+	}{
+		{"bad version", corrupt(func(b []byte) []byte { b[0] = 9; return b }), ErrUnsupportedVersion},
+		{"flipped payload bit", corrupt(func(b []byte) []byte { b[headerSize] ^= 1; return b }), ErrChecksumMismatch},
+		{"truncated prefix", frame[:prefixSize-1], io.ErrUnexpectedEOF},
+		{"truncated header", frame[:headerSize-1], io.ErrUnexpectedEOF},
+		{"truncated payload", frame[:headerSize+2], io.ErrUnexpectedEOF},
+		{"truncated checksum", frame[:len(frame)-1], io.ErrUnexpectedEOF},
+		{"trailing bytes", append(corrupt(func(b []byte) []byte { return b }), 0), ErrTrailingBytes},
+		{"oversized payload", corrupt(func(b []byte) []byte {
+			binary.BigEndian.PutUint32(b[headerSize-lengthSize:headerSize], MaxPayloadSize+1)
+			return b
+		}), ErrPayloadTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Unmarshal(tt.frame)
+			require.ErrorIs(t, err, tt.want)
+		})
+	}
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add(int32(domain.StartNewRound), int64(0), []byte(nil))
+	f.Add(int32(domain.ReceivedAnswer), int64(1663000000000000000), []byte("answer"))
+	f.Add(int32(-1), int64(-1), []byte{0, 1, 2, 255})
+
+	f.Fuzz(func(t *testing.T, mt int32, ts int64, data []byte) {
+		msg := withCorrelationID(newMessage(domain.MessageType(mt), data, ts), string(data))
+		if len(data) <= MaxIDSize {
+			msg = withID(msg, string(data), uint64(ts))
+		}
+
+		frame, err := Marshal(msg)
+		require.NoError(t, err)
+
+		got, err := Unmarshal(frame)
+		require.NoError(t, err)
+		require.Equal(t, msg.Type(), got.Type())
+		require.Equal(t, msg.Timestamp, got.Timestamp)
+		require.True(t, bytes.Equal(msg.Data, got.Data))
+		require.Equal(t, msg.CorrelationID, got.CorrelationID)
+		require.Equal(t, msg.ID, got.ID)
+		require.Equal(t, msg.Sequence, got.Sequence)
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, msg := range []domain.Message{
+		newMessage(domain.StartNewRound, nil, 0),
+		newMessage(domain.ReceivedAnswer, []byte("answer"), 42),
+		withCorrelationID(newMessage(domain.ReceivedAnswer, nil, 42), "round-1"),
+		withID(newMessage(domain.StartNewRound, []byte("round"), 42), "id", 7),
+	} {
+		frame, err := Marshal(msg)
+		require.NoError(f, err)
+		f.Add(frame)
+		f.Add(marshalV2(msg))
+		f.Add(marshalV1(msg))
+	}
+	f.Add([]byte{})
+	f.Add([]byte{Version1, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		msg, err := Unmarshal(b)
+		if err != nil {
+			return
+		}
+
+		// any current frame that decodes must encode back to the same
+		// bytes and an older one to the same message
+		frame, err := Marshal(*msg)
+		require.NoError(t, err)
+		if b[0] == CurrentVersion {
+			require.Equal(t, b, frame)
+			return
+		}
+
+		again, err := Unmarshal(frame)
+		require.NoError(t, err)
+		require.Equal(t, msg, again)
+	})
+}
@@ -2,27 +2,25 @@ package domain
 
 type MessageType int
 
+// message types defined by the protocol.  additional types are added at
+// startup with RegisterMessageType.
 const (
 	StartNewRound MessageType = iota << 1
 	ReceivedAnswer
 )
 
 func (m MessageType) String() string {
-	switch m {
-	case StartNewRound:
-		return "StartNewRound"
-	case ReceivedAnswer:
-		return "ReceivedAnswer"
-	default:
-		return "Unknown"
+	if info, ok := m.Info(); ok {
+		return info.Name
 	}
+	return "Unknown"
 }
 
 /*
 	number of messages held in memory for each
 
-message type.  additional messages are added by dropping
-the oldest message
+message type that has no declared RetentionPolicy.  additional
+messages are added by dropping the oldest message
 */
 var PriorityQueueCapacity int = 100
 
@@ -30,6 +28,17 @@ type Message struct {
 	msgType   MessageType
 	Data      []byte
 	Timestamp int64
+	// CorrelationID ties a reply to the request it answers.  it is empty
+	// for messages that are not part of an exchange.
+	CorrelationID string
+	// ID identifies the message.  the relayer assigns one at ingress to a
+	// message read without an ID.
+	ID string
+	// Sequence numbers the messages of a type in the order the relayer
+	// read them, starting at 1, or after the highest number in a mailbox
+	// that replays them, such as a WALMailbox.  it is zero for a message
+	// that did not go through a relayer.
+	Sequence uint64
 }
 
 func NewMessage(t MessageType, d []byte) Message {
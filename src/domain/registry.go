@@ -0,0 +1,185 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrEmptyMessageTypeName     = errors.New("message type name is empty")
+	ErrDuplicateMessageTypeName = errors.New("message type name is already registered")
+)
+
+// LowestPriority is the priority of message types that are not registered.
+const LowestPriority = math.MaxInt
+
+// MessageTypeInfo describes a registered message type.  types with a lower
+// Priority are broadcast first.
+type MessageTypeInfo struct {
+	Type      MessageType
+	Name      string
+	Priority  int
+	Retention RetentionPolicy
+}
+
+type registry struct {
+	mu     sync.RWMutex
+	next   MessageType
+	byType map[MessageType]MessageTypeInfo
+	byName map[string]MessageType
+}
+
+var types = &registry{
+	mu:     sync.RWMutex{},
+	byType: make(map[MessageType]MessageTypeInfo),
+	byName: make(map[string]MessageType),
+}
+
+func init() {
+	types.mustRegister(MessageTypeInfo{
+		Type:      StartNewRound,
+		Name:      "StartNewRound",
+		Priority:  0,
+		Retention: KeepLast(2),
+	})
+	types.mustRegister(MessageTypeInfo{
+		Type:      ReceivedAnswer,
+		Name:      "ReceivedAnswer",
+		Priority:  1,
+		Retention: KeepNewest(),
+	})
+}
+
+// RegisterMessageType adds a message type to the registry and returns its
+// value.  types are meant to be registered once at startup.
+func RegisterMessageType(name string, priority int, retention RetentionPolicy) (MessageType, error) {
+	types.mu.Lock()
+	defer types.mu.Unlock()
+
+	info := MessageTypeInfo{
+		Type:      types.next,
+		Name:      name,
+		Priority:  priority,
+		Retention: retention,
+	}
+
+	if err := types.register(info); err != nil {
+		return 0, err
+	}
+
+	return info.Type, nil
+}
+
+// MustRegisterMessageType is like RegisterMessageType but panics on error.
+func MustRegisterMessageType(name string, priority int, retention RetentionPolicy) MessageType {
+	mt, err := RegisterMessageType(name, priority, retention)
+	if err != nil {
+		panic(err)
+	}
+	return mt
+}
+
+// LookupMessageType returns the type registered under name.
+func LookupMessageType(name string) (MessageType, bool) {
+	types.mu.RLock()
+	defer types.mu.RUnlock()
+
+	mt, ok := types.byName[name]
+	return mt, ok
+}
+
+// MessageTypes returns every registered type in broadcast order.
+func MessageTypes() []MessageTypeInfo {
+	types.mu.RLock()
+	defer types.mu.RUnlock()
+
+	infos := make([]MessageTypeInfo, 0, len(types.byType))
+	for _, info := range types.byType {
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].before(infos[j])
+	})
+
+	return infos
+}
+
+// Info returns the registration of m.
+func (m MessageType) Info() (MessageTypeInfo, bool) {
+	types.mu.RLock()
+	defer types.mu.RUnlock()
+
+	info, ok := types.byType[m]
+	return info, ok
+}
+
+// Registered reports whether m has been registered.
+func (m MessageType) Registered() bool {
+	_, ok := m.Info()
+	return ok
+}
+
+// Priority returns the registered priority of m, or LowestPriority.
+func (m MessageType) Priority() int {
+	if info, ok := m.Info(); ok {
+		return info.Priority
+	}
+	return LowestPriority
+}
+
+// Retention returns the registered retention policy of m, or
+// DefaultRetention.
+func (m MessageType) Retention() RetentionPolicy {
+	if info, ok := m.Info(); ok {
+		return info.Retention
+	}
+	return DefaultRetention()
+}
+
+// ByPriority orders message types for broadcast: lower priority first and
+// ties broken by type value.
+func ByPriority(a, b MessageType) bool {
+	pa, pb := a.Priority(), b.Priority()
+	if pa != pb {
+		return pa < pb
+	}
+	return a < b
+}
+
+func (info MessageTypeInfo) before(other MessageTypeInfo) bool {
+	if info.Priority != other.Priority {
+		return info.Priority < other.Priority
+	}
+	return info.Type < other.Type
+}
+
+func (r *registry) mustRegister(info MessageTypeInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.register(info); err != nil {
+		panic(err)
+	}
+}
+
+// register adds info to the registry.  the caller must hold the lock.
+func (r *registry) register(info MessageTypeInfo) error {
+	if info.Name == "" {
+		return ErrEmptyMessageTypeName
+	}
+	if _, ok := r.byName[info.Name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateMessageTypeName, info.Name)
+	}
+
+	r.byType[info.Type] = info
+	r.byName[info.Name] = info.Type
+	if info.Type >= r.next {
+		r.next = info.Type + 1
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package domain
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var registrations atomic.Int64
+
+// uniqueName keeps registrations distinct when tests run more than once.
+func uniqueName(t *testing.T) string {
+	return fmt.Sprintf("%s_%d", t.Name(), registrations.Add(1))
+}
+
+func Test_builtin_message_types_are_registered(t *testing.T) {
+	require.Equal(t, "StartNewRound", StartNewRound.String())
+	require.Equal(t, "ReceivedAnswer", ReceivedAnswer.String())
+	require.True(t, ByPriority(StartNewRound, ReceivedAnswer))
+
+	mt, ok := LookupMessageType("ReceivedAnswer")
+	require.True(t, ok)
+	require.Equal(t, ReceivedAnswer, mt)
+}
+
+func Test_RegisterMessageType(t *testing.T) {
+	name := uniqueName(t)
+	mt, err := RegisterMessageType(name, 5, KeepLast(3))
+	require.NoError(t, err)
+
+	require.NotEqual(t, StartNewRound, mt)
+	require.NotEqual(t, ReceivedAnswer, mt)
+	require.Equal(t, name, mt.String())
+	require.Equal(t, 5, mt.Priority())
+	require.Equal(t, KeepLast(3), mt.Retention())
+	require.True(t, ByPriority(ReceivedAnswer, mt))
+
+	found, ok := LookupMessageType(name)
+	require.True(t, ok)
+	require.Equal(t, mt, found)
+	require.Contains(t, MessageTypes(), MessageTypeInfo{
+		Type:      mt,
+		Name:      name,
+		Priority:  5,
+		Retention: KeepLast(3),
+	})
+}
+
+func Test_RegisterMessageType_rejects_invalid_names(t *testing.T) {
+	_, err := RegisterMessageType("", 0, KeepAll())
+	require.ErrorIs(t, err, ErrEmptyMessageTypeName)
+
+	_, err = RegisterMessageType("StartNewRound", 0, KeepAll())
+	require.ErrorIs(t, err, ErrDuplicateMessageTypeName)
+}
+
+func Test_unregistered_message_type(t *testing.T) {
+	mt := MessageType(-1)
+
+	require.False(t, mt.Registered())
+	require.Equal(t, "Unknown", mt.String())
+	require.Equal(t, LowestPriority, mt.Priority())
+	require.Equal(t, DefaultRetention(), mt.Retention())
+}
+
+func Test_MessageTypes_are_in_broadcast_order(t *testing.T) {
+	urgent := MustRegisterMessageType(uniqueName(t), -1, KeepAll())
+
+	infos := MessageTypes()
+
+	index := make(map[MessageType]int)
+	for i, info := range infos {
+		index[info.Type] = i
+		if i > 0 {
+			require.True(t, ByPriority(infos[i-1].Type, info.Type))
+		}
+	}
+	require.Less(t, index[urgent], index[StartNewRound])
+	require.Less(t, index[StartNewRound], index[ReceivedAnswer])
+}
@@ -0,0 +1,63 @@
+package domain
+
+// RetentionPolicy bounds the messages of a single type that are held in
+// memory.  once a policy is exceeded the oldest messages of that type are
+// evicted until it holds again.  a zero limit is unbounded.
+type RetentionPolicy struct {
+	MaxCount int // most messages kept
+	MaxBytes int // most payload bytes kept
+}
+
+// KeepLast keeps the n most recent messages.
+func KeepLast(n int) RetentionPolicy {
+	return RetentionPolicy{MaxCount: n}
+}
+
+// KeepNewest keeps only the most recent message.
+func KeepNewest() RetentionPolicy {
+	return KeepLast(1)
+}
+
+// KeepBytes keeps the most recent messages whose payloads fit in n bytes.
+func KeepBytes(n int) RetentionPolicy {
+	return RetentionPolicy{MaxBytes: n}
+}
+
+// KeepAll keeps every message.
+func KeepAll() RetentionPolicy {
+	return RetentionPolicy{}
+}
+
+// Exceeded reports whether holding count messages with a combined payload
+// of size bytes violates the policy.
+func (p RetentionPolicy) Exceeded(count, size int) bool {
+	if p.MaxCount > 0 && count > p.MaxCount {
+		return true
+	}
+	return p.MaxBytes > 0 && size > p.MaxBytes
+}
+
+// DefaultRetention is the policy of unregistered types: keep the last
+// PriorityQueueCapacity messages.
+func DefaultRetention() RetentionPolicy {
+	return KeepLast(PriorityQueueCapacity)
+}
+
+// RetentionPolicies declares a retention policy per message type.
+type RetentionPolicies map[MessageType]RetentionPolicy
+
+// ProtocolRetention keeps the 2 most recent StartNewRound messages and only
+// the most recent ReceivedAnswer.
+var ProtocolRetention = RetentionPolicies{
+	StartNewRound:  KeepLast(2),
+	ReceivedAnswer: KeepNewest(),
+}
+
+// For returns the policy declared for mt, falling back on the policy mt
+// was registered with.
+func (rp RetentionPolicies) For(mt MessageType) RetentionPolicy {
+	if p, ok := rp[mt]; ok {
+		return p
+	}
+	return mt.Retention()
+}
@@ -0,0 +1,39 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RetentionPolicy_Exceeded(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		count  int
+		size   int
+		want   bool
+	}{
+		{"keep last within count", KeepLast(2), 2, 1000, false},
+		{"keep last over count", KeepLast(2), 3, 0, true},
+		{"keep newest over count", KeepNewest(), 2, 0, true},
+		{"keep bytes within budget", KeepBytes(10), 50, 10, false},
+		{"keep bytes over budget", KeepBytes(10), 1, 11, true},
+		{"keep all is unbounded", KeepAll(), 1 << 20, 1 << 30, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.policy.Exceeded(tt.count, tt.size))
+		})
+	}
+}
+
+func Test_RetentionPolicies_For_falls_back_on_registry(t *testing.T) {
+	policies := RetentionPolicies{StartNewRound: KeepNewest()}
+
+	require.Equal(t, KeepNewest(), policies.For(StartNewRound))
+	require.Equal(t, KeepNewest(), policies.For(ReceivedAnswer))
+	require.Equal(t, KeepLast(2), RetentionPolicies(nil).For(StartNewRound))
+	require.Equal(t, DefaultRetention(), policies.For(MessageType(-1)))
+}
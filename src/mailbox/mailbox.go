@@ -5,6 +5,10 @@ import "context"
 type Mailbox[T any] interface {
 	Add(T)
 	Empty(context.Context) <-chan T
+	// Ready receives a value once a message is added, so a reader can
+	// empty the mailbox without polling it.  adds that happen before the
+	// value is taken share it.
+	Ready() <-chan struct{}
 }
 
 type Emptier[T any] interface {
@@ -17,7 +21,28 @@ type Stack[T any] interface {
 	PushFront(T)     // place item on top of stack
 }
 
+// Ordered is implemented by stacks that pop and empty their oldest item
+// first, like a queue, when OldestFirst returns true.  other stacks empty
+// their newest item first.
+type Ordered interface {
+	OldestFirst() bool
+}
+
 type StackEmptier[T any] interface {
 	Stack[T]
 	Emptier[T]
 }
+
+// signal wakes the reader of a mailbox without blocking the writer.
+type signal chan struct{}
+
+func newSignal() signal {
+	return make(signal, 1)
+}
+
+func (s signal) notify() {
+	select {
+	case s <- struct{}{}:
+	default:
+	}
+}
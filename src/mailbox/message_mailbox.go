@@ -2,26 +2,98 @@ package mailbox
 
 import (
 	"context"
+	"sort"
+	"sync"
 
 	"github.com/mstreet3/message-relayer/domain"
 )
 
+// usage is what the messages of a type that are kept count against its
+// retention policy.
+type usage struct {
+	count int
+	bytes int
+	// sizes holds the size of every counted message, oldest first
+	sizes []int
+}
+
+func (u *usage) add(size int) {
+	u.count++
+	u.bytes += size
+	u.sizes = append(u.sizes, size)
+}
+
+// evictOldest stops counting the oldest message.
+func (u *usage) evictOldest() {
+	u.count--
+	u.bytes -= u.sizes[0]
+	u.sizes = u.sizes[1:]
+}
+
 type MessageMailbox struct {
-	cap     int
-	emptier Emptier[domain.Message]
-	stack   Stack[domain.Message]
+	mu       sync.Mutex
+	policies domain.RetentionPolicies
+	usage    map[domain.MessageType]*usage
+	emptier  Emptier[domain.Message]
+	stack    Stack[domain.Message]
+	ready    signal
+	// oldestFirst is set when the stack empties its oldest message first
+	oldestFirst bool
+	// live is the number of messages on the stack that are not evicted
+	live int
 }
 
-func NewMessageMailbox(c int, empt StackEmptier[domain.Message]) *MessageMailbox {
-	return &MessageMailbox{
-		cap:     c,
-		emptier: empt,
-		stack:   empt,
+// NewMessageMailbox returns a mailbox that keeps its messages on empt and
+// empties them in empt's order: newest first for a stack, oldest first for
+// a stack that is Ordered, or in the order of a priority queue's
+// comparator.
+func NewMessageMailbox(policies domain.RetentionPolicies, empt StackEmptier[domain.Message]) *MessageMailbox {
+	q := &MessageMailbox{
+		mu:       sync.Mutex{},
+		policies: policies,
+		usage:    make(map[domain.MessageType]*usage),
+		emptier:  empt,
+		stack:    empt,
+		ready:    newSignal(),
 	}
+
+	if o, ok := empt.(Ordered); ok {
+		q.oldestFirst = o.OldestFirst()
+	}
+
+	return q
 }
 
+// Add places msg on the stack and evicts the oldest messages of the same
+// type until the type's retention policy holds.  evicted messages stay on
+// the stack until it holds more evicted messages than kept ones, or until
+// it is emptied, so an Add does not walk the stack.
 func (q *MessageMailbox) Add(msg domain.Message) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	mt := msg.Type()
 	q.stack.PushFront(msg)
+
+	u, ok := q.usage[mt]
+	if !ok {
+		u = &usage{}
+		q.usage[mt] = u
+	}
+	u.add(len(msg.Data))
+	q.live++
+
+	policy := q.policies.For(mt)
+	for u.count > 0 && policy.Exceeded(u.count, u.bytes) {
+		u.evictOldest()
+		q.live--
+	}
+
+	if q.stack.Len() > 2*q.live {
+		q.compact()
+	}
+
+	q.ready.notify()
 }
 
 // Empty drains the queue and puts all found values onto a channel
@@ -44,6 +116,104 @@ func (q *MessageMailbox) Empty(ctx context.Context) <-chan domain.Message {
 	return msgCh
 }
 
+func (q *MessageMailbox) Ready() <-chan struct{} {
+	return q.ready
+}
+
 func (q *MessageMailbox) empty() []domain.Message {
-	return q.emptier.Empty()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msgs := q.kept(q.emptier.Empty())
+	q.usage = make(map[domain.MessageType]*usage)
+	q.live = 0
+
+	return msgs
+}
+
+// compact rebuilds the stack without the evicted messages.  the caller
+// must hold the lock.
+func (q *MessageMailbox) compact() {
+	msgs := q.oldest(q.kept(q.emptier.Empty()))
+
+	// count what is kept, since a bounded stack may have overwritten
+	// messages that were still counted
+	q.usage = make(map[domain.MessageType]*usage)
+	q.live = len(msgs)
+
+	// pushing the oldest first restores the order of any stack
+	for _, msg := range msgs {
+		q.stack.PushFront(msg)
+
+		u, ok := q.usage[msg.Type()]
+		if !ok {
+			u = &usage{}
+			q.usage[msg.Type()] = u
+		}
+		u.add(len(msg.Data))
+	}
+}
+
+// kept returns msgs, as emptied from the stack, without the evicted
+// messages.  the newest messages of each type are the ones still counted,
+// and the oldest are either evicted or were overwritten by a bounded stack.
+// the caller must hold the lock.
+func (q *MessageMailbox) kept(msgs []domain.Message) []domain.Message {
+	var (
+		remaining = make(map[domain.MessageType]int, len(q.usage))
+		keep      = make([]bool, len(msgs))
+		kept      = make([]domain.Message, 0, q.live)
+	)
+
+	for mt, u := range q.usage {
+		remaining[mt] = u.count
+	}
+
+	age := q.byAge(msgs)
+	for j := len(age) - 1; j >= 0; j-- {
+		i := age[j]
+		if mt := msgs[i].Type(); remaining[mt] > 0 {
+			remaining[mt]--
+			keep[i] = true
+		}
+	}
+
+	for i, msg := range msgs {
+		if keep[i] {
+			kept = append(kept, msg)
+		}
+	}
+
+	return kept
+}
+
+// byAge returns the indexes of msgs, as emptied from the stack, from the
+// oldest message to the newest.  messages are aged by Timestamp, which the
+// relayer sets when it reads them, and by their place on the stack when
+// timestamps tie, so a stack that empties in another order, such as a
+// priority queue, still evicts its oldest messages.
+func (q *MessageMailbox) byAge(msgs []domain.Message) []int {
+	idx := make([]int, len(msgs))
+	for i := range idx {
+		if q.oldestFirst {
+			idx[i] = i
+		} else {
+			idx[i] = len(msgs) - 1 - i
+		}
+	}
+
+	sort.SliceStable(idx, func(a, b int) bool {
+		return msgs[idx[a]].Timestamp < msgs[idx[b]].Timestamp
+	})
+	return idx
+}
+
+// oldest puts msgs, as emptied from the stack, in order from oldest to
+// newest.
+func (q *MessageMailbox) oldest(msgs []domain.Message) []domain.Message {
+	sorted := make([]domain.Message, 0, len(msgs))
+	for _, i := range q.byAge(msgs) {
+		sorted = append(sorted, msgs[i])
+	}
+	return sorted
 }
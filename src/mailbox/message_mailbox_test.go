@@ -0,0 +1,185 @@
+package mailbox
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mstreet3/message-relayer/domain"
+	fifo "github.com/mstreet3/message-relayer/queues/fifoqueue"
+	lifo "github.com/mstreet3/message-relayer/queues/lifoqueue"
+	"github.com/mstreet3/message-relayer/queues/priorityqueue"
+	ring "github.com/mstreet3/message-relayer/queues/ringbuffer"
+	"github.com/stretchr/testify/require"
+)
+
+// collect empties the mailbox without going through its channel, which
+// drops messages when the listener is not ready.
+func collect(mb *MessageMailbox) []domain.Message {
+	return mb.empty()
+}
+
+func newMessageMailbox(policies domain.RetentionPolicies) *MessageMailbox {
+	return NewMessageMailbox(policies, lifo.NewLIFOQueue[domain.Message]())
+}
+
+func Test_MessageMailbox_keep_last_evicts_oldest(t *testing.T) {
+	mb := newMessageMailbox(domain.RetentionPolicies{
+		domain.StartNewRound: domain.KeepLast(2),
+	})
+
+	mb.Add(domain.NewMessage(domain.StartNewRound, []byte("first")))
+	mb.Add(domain.NewMessage(domain.ReceivedAnswer, []byte("answer")))
+	mb.Add(domain.NewMessage(domain.StartNewRound, []byte("second")))
+	mb.Add(domain.NewMessage(domain.StartNewRound, []byte("third")))
+
+	got := collect(mb)
+
+	require.Len(t, got, 3)
+	require.Equal(t, []byte("third"), got[0].Data)
+	require.Equal(t, []byte("second"), got[1].Data)
+	require.Equal(t, []byte("answer"), got[2].Data)
+}
+
+func Test_MessageMailbox_keep_newest(t *testing.T) {
+	mb := newMessageMailbox(nil)
+
+	for _, d := range []string{"first", "second", "third"} {
+		mb.Add(domain.NewMessage(domain.ReceivedAnswer, []byte(d)))
+	}
+
+	got := collect(mb)
+
+	require.Len(t, got, 1)
+	require.Equal(t, []byte("third"), got[0].Data)
+}
+
+func Test_MessageMailbox_keep_bytes_evicts_until_within_budget(t *testing.T) {
+	mb := newMessageMailbox(domain.RetentionPolicies{
+		domain.StartNewRound: domain.KeepBytes(8),
+	})
+
+	mb.Add(domain.NewMessage(domain.StartNewRound, []byte("aaaa")))
+	mb.Add(domain.NewMessage(domain.StartNewRound, []byte("bbbb")))
+	mb.Add(domain.NewMessage(domain.StartNewRound, []byte("cccccc")))
+
+	got := collect(mb)
+
+	require.Len(t, got, 1)
+	require.Equal(t, []byte("cccccc"), got[0].Data)
+}
+
+func Test_MessageMailbox_empty_resets_retention(t *testing.T) {
+	mb := newMessageMailbox(domain.RetentionPolicies{
+		domain.StartNewRound: domain.KeepLast(2),
+	})
+
+	mb.Add(domain.NewMessage(domain.StartNewRound, nil))
+	mb.Add(domain.NewMessage(domain.StartNewRound, nil))
+	require.Len(t, collect(mb), 2)
+
+	mb.Add(domain.NewMessage(domain.StartNewRound, nil))
+	mb.Add(domain.NewMessage(domain.StartNewRound, nil))
+	require.Len(t, collect(mb), 2)
+}
+
+func Test_MessageMailbox_fifo_keep_last_evicts_oldest(t *testing.T) {
+	mb := NewMessageMailbox(domain.RetentionPolicies{
+		domain.StartNewRound: domain.KeepLast(2),
+	}, fifo.NewFIFOQueue[domain.Message]())
+
+	mb.Add(domain.NewMessage(domain.StartNewRound, []byte("first")))
+	mb.Add(domain.NewMessage(domain.ReceivedAnswer, []byte("answer")))
+	mb.Add(domain.NewMessage(domain.StartNewRound, []byte("second")))
+	mb.Add(domain.NewMessage(domain.StartNewRound, []byte("third")))
+
+	got := collect(mb)
+
+	require.Len(t, got, 3)
+	require.Equal(t, []byte("answer"), got[0].Data)
+	require.Equal(t, []byte("second"), got[1].Data)
+	require.Equal(t, []byte("third"), got[2].Data)
+}
+
+func Test_MessageMailbox_priority_queue_keep_last_evicts_oldest(t *testing.T) {
+	mb := NewMessageMailbox(domain.RetentionPolicies{
+		domain.StartNewRound: domain.KeepLast(2),
+	}, priorityqueue.NewPriorityQueue(func(a, b domain.Message) bool {
+		return string(a.Data) < string(b.Data)
+	}))
+
+	// the queue's order is not the order of age, and the fifth add
+	// compacts it
+	for i, data := range []string{"c", "a", "e", "d", "b"} {
+		msg := domain.NewMessage(domain.StartNewRound, []byte(data))
+		msg.Timestamp = int64(i + 1)
+		mb.Add(msg)
+	}
+
+	got := collect(mb)
+
+	require.Len(t, got, 2)
+	require.Equal(t, []byte("b"), got[0].Data)
+	require.Equal(t, []byte("d"), got[1].Data)
+}
+
+func Test_MessageMailbox_ring_buffer_counts_overwritten_messages(t *testing.T) {
+	mb := NewMessageMailbox(domain.RetentionPolicies{
+		domain.StartNewRound: domain.KeepLast(2),
+	}, ring.NewRingBuffer[domain.Message](2))
+
+	// the buffer overwrites the first round, so the answer is not evicted
+	mb.Add(domain.NewMessage(domain.StartNewRound, []byte("first")))
+	mb.Add(domain.NewMessage(domain.StartNewRound, []byte("second")))
+	mb.Add(domain.NewMessage(domain.ReceivedAnswer, []byte("answer")))
+	mb.Add(domain.NewMessage(domain.StartNewRound, []byte("third")))
+
+	got := collect(mb)
+
+	require.Len(t, got, 2)
+	require.Equal(t, []byte("answer"), got[0].Data)
+	require.Equal(t, []byte("third"), got[1].Data)
+}
+
+func Test_MessageMailbox_ready_after_add(t *testing.T) {
+	mb := newMessageMailbox(nil)
+
+	select {
+	case <-mb.Ready():
+		t.Fatal("ready before any add")
+	default:
+	}
+
+	// adds before the signal is taken share it
+	mb.Add(domain.NewMessage(domain.StartNewRound, nil))
+	mb.Add(domain.NewMessage(domain.StartNewRound, nil))
+
+	<-mb.Ready()
+	select {
+	case <-mb.Ready():
+		t.Fatal("ready twice for one batch")
+	default:
+	}
+}
+
+func Test_MessageMailbox_keeps_evicted_messages_bounded(t *testing.T) {
+	var (
+		stack = lifo.NewLIFOQueue[domain.Message]()
+		mb    = NewMessageMailbox(domain.RetentionPolicies{
+			domain.StartNewRound: domain.KeepLast(2),
+		}, stack)
+	)
+
+	mb.Add(domain.NewMessage(domain.ReceivedAnswer, []byte("answer")))
+	for i := 0; i < 1000; i++ {
+		mb.Add(domain.NewMessage(domain.StartNewRound, []byte(fmt.Sprint(i))))
+		// evicted messages are dropped once they outnumber the kept ones
+		require.LessOrEqual(t, stack.Len(), 6)
+	}
+
+	got := collect(mb)
+
+	require.Len(t, got, 3)
+	require.Equal(t, []byte("999"), got[0].Data)
+	require.Equal(t, []byte("998"), got[1].Data)
+	require.Equal(t, []byte("answer"), got[2].Data)
+}
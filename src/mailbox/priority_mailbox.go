@@ -0,0 +1,191 @@
+package mailbox
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mstreet3/message-relayer/domain"
+)
+
+// PriorityRule overrides the retention policy that a message type was
+// registered with.  ranked types are emptied before all others, in the order
+// their rules are declared.
+type PriorityRule struct {
+	Type      domain.MessageType
+	Retention domain.RetentionPolicy
+}
+
+// ProtocolRules are the protocol's broadcast rules: keep the 2 most recent
+// StartNewRound messages and only the most recent ReceivedAnswer, and always
+// broadcast StartNewRound before ReceivedAnswer.
+var ProtocolRules = []PriorityRule{
+	{Type: domain.StartNewRound, Retention: domain.ProtocolRetention.For(domain.StartNewRound)},
+	{Type: domain.ReceivedAnswer, Retention: domain.ProtocolRetention.For(domain.ReceivedAnswer)},
+}
+
+// PriorityMailbox keeps a bounded queue per message type.  adding a message
+// that exceeds its type's retention policy drops the oldest messages of that
+// type.  queues of ranked types are emptied first, followed by every other
+// type in registered priority order, oldest message first within a type.
+// without rules the mailbox enforces the protocol: the 2 most recent
+// StartNewRound messages are broadcast before the most recent ReceivedAnswer.
+type PriorityMailbox struct {
+	mu     sync.Mutex
+	ranked []domain.MessageType
+	rules  map[domain.MessageType]domain.RetentionPolicy
+	queues map[domain.MessageType]*boundedQueue
+	ready  signal
+}
+
+var _ Mailbox[domain.Message] = (*PriorityMailbox)(nil)
+
+func NewPriorityMailbox(rules []PriorityRule) *PriorityMailbox {
+	pm := &PriorityMailbox{
+		mu:     sync.Mutex{},
+		ranked: make([]domain.MessageType, 0, len(rules)),
+		rules:  make(map[domain.MessageType]domain.RetentionPolicy),
+		queues: make(map[domain.MessageType]*boundedQueue),
+		ready:  newSignal(),
+	}
+
+	for _, r := range rules {
+		if _, ok := pm.rules[r.Type]; ok {
+			continue
+		}
+		pm.ranked = append(pm.ranked, r.Type)
+		pm.rules[r.Type] = r.Retention
+	}
+
+	return pm
+}
+
+func (pm *PriorityMailbox) Add(msg domain.Message) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	mt := msg.Type()
+	q, ok := pm.queues[mt]
+	if !ok {
+		q = newBoundedQueue(domain.RetentionPolicies(pm.rules).For(mt))
+		pm.queues[mt] = q
+	}
+
+	q.push(msg)
+	pm.ready.notify()
+}
+
+// Empty drains every queue in priority order and puts the messages onto a
+// channel.  unlike MessageMailbox no message is dropped while the context
+// is live, so the retained messages are always broadcast.
+func (pm *PriorityMailbox) Empty(ctx context.Context) <-chan domain.Message {
+	var (
+		msgCh = make(chan domain.Message, 1)
+		msgs  = pm.empty()
+	)
+
+	go func() {
+		defer close(msgCh)
+		for _, msg := range msgs {
+			select {
+			case <-ctx.Done():
+				return
+			case msgCh <- msg:
+			}
+		}
+	}()
+
+	return msgCh
+}
+
+func (pm *PriorityMailbox) Ready() <-chan struct{} {
+	return pm.ready
+}
+
+// Len returns the number of messages currently queued across all types.
+func (pm *PriorityMailbox) Len() int {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	n := 0
+	for _, q := range pm.queues {
+		n += len(q.msgs)
+	}
+	return n
+}
+
+func (pm *PriorityMailbox) empty() []domain.Message {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	msgs := make([]domain.Message, 0)
+	for _, mt := range pm.order() {
+		msgs = append(msgs, pm.queues[mt].drain()...)
+	}
+
+	return msgs
+}
+
+// order returns the types with a queue in the order they are emptied.  the
+// caller must hold the lock.
+func (pm *PriorityMailbox) order() []domain.MessageType {
+	var (
+		order    = make([]domain.MessageType, 0, len(pm.queues))
+		unranked = make([]domain.MessageType, 0, len(pm.queues))
+	)
+
+	for _, mt := range pm.ranked {
+		if _, ok := pm.queues[mt]; ok {
+			order = append(order, mt)
+		}
+	}
+
+	for mt := range pm.queues {
+		if _, ok := pm.rules[mt]; !ok {
+			unranked = append(unranked, mt)
+		}
+	}
+
+	sort.Slice(unranked, func(i, j int) bool {
+		return domain.ByPriority(unranked[i], unranked[j])
+	})
+
+	return append(order, unranked...)
+}
+
+// boundedQueue is a FIFO that drops its oldest entries while its retention
+// policy is exceeded.  it is not safe for concurrent use.
+type boundedQueue struct {
+	policy domain.RetentionPolicy
+	bytes  int
+	msgs   []domain.Message
+}
+
+func newBoundedQueue(p domain.RetentionPolicy) *boundedQueue {
+	return &boundedQueue{
+		policy: p,
+		msgs:   make([]domain.Message, 0, p.MaxCount),
+	}
+}
+
+func (bq *boundedQueue) push(msg domain.Message) {
+	bq.msgs = append(bq.msgs, msg)
+	bq.bytes += len(msg.Data)
+
+	drop := 0
+	for drop < len(bq.msgs) && bq.policy.Exceeded(len(bq.msgs)-drop, bq.bytes) {
+		bq.bytes -= len(bq.msgs[drop].Data)
+		drop++
+	}
+
+	if drop > 0 {
+		bq.msgs = append(bq.msgs[:0], bq.msgs[drop:]...)
+	}
+}
+
+func (bq *boundedQueue) drain() []domain.Message {
+	msgs := bq.msgs
+	bq.msgs = make([]domain.Message, 0, bq.policy.MaxCount)
+	bq.bytes = 0
+	return msgs
+}
@@ -0,0 +1,156 @@
+package mailbox
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mstreet3/message-relayer/domain"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	urgent = domain.MustRegisterMessageType("Test_PriorityMailbox_urgent", -1, domain.KeepNewest())
+	late   = domain.MustRegisterMessageType("Test_PriorityMailbox_late", 10, domain.KeepAll())
+)
+
+func drain(ctx context.Context, mb Mailbox[domain.Message]) []domain.Message {
+	msgs := make([]domain.Message, 0)
+	for msg := range mb.Empty(ctx) {
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func Test_PriorityMailbox_keeps_two_most_recent_StartNewRound_priority(t *testing.T) {
+	pm := NewPriorityMailbox(nil)
+	for _, d := range []string{"first", "second", "third"} {
+		pm.Add(domain.NewMessage(domain.StartNewRound, []byte(d)))
+	}
+
+	got := drain(context.Background(), pm)
+
+	require.Len(t, got, 2)
+	require.Equal(t, []byte("second"), got[0].Data)
+	require.Equal(t, []byte("third"), got[1].Data)
+}
+
+func Test_PriorityMailbox_keeps_most_recent_ReceivedAnswer_priority(t *testing.T) {
+	pm := NewPriorityMailbox(nil)
+	for _, d := range []string{"first", "second", "third"} {
+		pm.Add(domain.NewMessage(domain.ReceivedAnswer, []byte(d)))
+	}
+
+	got := drain(context.Background(), pm)
+
+	require.Len(t, got, 1)
+	require.Equal(t, []byte("third"), got[0].Data)
+}
+
+func Test_PriorityMailbox_broadcasts_StartNewRound_first_priority(t *testing.T) {
+	pm := NewPriorityMailbox(nil)
+	pm.Add(domain.NewMessage(domain.ReceivedAnswer, nil))
+	pm.Add(domain.NewMessage(domain.StartNewRound, nil))
+	pm.Add(domain.NewMessage(domain.ReceivedAnswer, nil))
+	pm.Add(domain.NewMessage(domain.StartNewRound, nil))
+
+	got := drain(context.Background(), pm)
+
+	require.Len(t, got, 3)
+	require.Equal(t, domain.StartNewRound, got[0].Type())
+	require.Equal(t, domain.StartNewRound, got[1].Type())
+	require.Equal(t, domain.ReceivedAnswer, got[2].Type())
+}
+
+func Test_PriorityMailbox_empty_drains_mailbox_priority(t *testing.T) {
+	pm := NewPriorityMailbox(nil)
+	pm.Add(domain.NewMessage(domain.StartNewRound, nil))
+	pm.Add(domain.NewMessage(domain.ReceivedAnswer, nil))
+
+	require.Len(t, drain(context.Background(), pm), 2)
+	require.Equal(t, 0, pm.Len())
+	require.Len(t, drain(context.Background(), pm), 0)
+}
+
+func Test_PriorityMailbox_stops_on_cancelled_context_priority(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	pm := NewPriorityMailbox(nil)
+	pm.Add(domain.NewMessage(domain.StartNewRound, nil))
+	pm.Add(domain.NewMessage(domain.StartNewRound, nil))
+
+	msgCh := pm.Empty(ctx)
+	for range msgCh {
+	}
+
+	_, open := <-msgCh
+	require.False(t, open)
+	require.Equal(t, 0, pm.Len())
+}
+
+func Test_PriorityMailbox_keep_bytes_priority(t *testing.T) {
+	pm := NewPriorityMailbox([]PriorityRule{
+		{Type: domain.StartNewRound, Retention: domain.KeepBytes(8)},
+	})
+
+	pm.Add(domain.NewMessage(domain.StartNewRound, []byte("aaaa")))
+	pm.Add(domain.NewMessage(domain.StartNewRound, []byte("bbbb")))
+	pm.Add(domain.NewMessage(domain.StartNewRound, []byte("cc")))
+
+	got := drain(context.Background(), pm)
+
+	require.Len(t, got, 2)
+	require.Equal(t, []byte("bbbb"), got[0].Data)
+	require.Equal(t, []byte("cc"), got[1].Data)
+}
+
+func Test_PriorityMailbox_orders_registered_types_priority(t *testing.T) {
+	pm := NewPriorityMailbox(nil)
+
+	pm.Add(domain.NewMessage(late, nil))
+	pm.Add(domain.NewMessage(domain.ReceivedAnswer, nil))
+	pm.Add(domain.NewMessage(late, nil))
+	pm.Add(domain.NewMessage(urgent, nil))
+	pm.Add(domain.NewMessage(urgent, nil))
+	pm.Add(domain.NewMessage(domain.StartNewRound, nil))
+
+	got := drain(context.Background(), pm)
+
+	types := make([]domain.MessageType, len(got))
+	for i, msg := range got {
+		types[i] = msg.Type()
+	}
+	require.Equal(t, []domain.MessageType{
+		urgent,
+		domain.StartNewRound,
+		domain.ReceivedAnswer,
+		late,
+		late,
+	}, types)
+}
+
+func Test_PriorityMailbox_rules_override_registry_priority(t *testing.T) {
+	pm := NewPriorityMailbox([]PriorityRule{
+		{Type: domain.ReceivedAnswer, Retention: domain.KeepLast(2)},
+	})
+
+	pm.Add(domain.NewMessage(domain.StartNewRound, nil))
+	pm.Add(domain.NewMessage(domain.ReceivedAnswer, nil))
+	pm.Add(domain.NewMessage(domain.ReceivedAnswer, nil))
+	pm.Add(domain.NewMessage(domain.ReceivedAnswer, nil))
+
+	got := drain(context.Background(), pm)
+
+	require.Len(t, got, 3)
+	require.Equal(t, domain.ReceivedAnswer, got[0].Type())
+	require.Equal(t, domain.ReceivedAnswer, got[1].Type())
+	require.Equal(t, domain.StartNewRound, got[2].Type())
+}
+
+func Test_PriorityMailbox_ready_after_add_priority(t *testing.T) {
+	pm := NewPriorityMailbox(nil)
+	pm.Add(domain.NewMessage(domain.ReceivedAnswer, nil))
+
+	<-pm.Ready()
+	require.Len(t, drain(context.Background(), pm), 1)
+}
@@ -0,0 +1,348 @@
+package mailbox
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mstreet3/message-relayer/codec"
+	"github.com/mstreet3/message-relayer/domain"
+)
+
+const (
+	segmentExt     = ".wal"
+	checkpointName = "checkpoint"
+	checkpointSize = 8 + 4
+)
+
+var (
+	ErrCorruptLog        = errors.New("mailbox: corrupt write-ahead log")
+	ErrCorruptCheckpoint = errors.New("mailbox: corrupt checkpoint")
+)
+
+// record is a message stored in the log along with its LSN.
+type record struct {
+	lsn uint64
+	msg domain.Message
+}
+
+// segment is a single file of the log.  its name is the LSN of its first
+// record.
+type segment struct {
+	base  uint64
+	count uint64
+	path  string
+}
+
+func (s segment) last() uint64 {
+	return s.base + s.count - 1
+}
+
+// segmentLog is an append-only log of codec frames split over segment
+// files.  the LSN of a record is the base LSN of its segment plus its index
+// in the segment; LSNs start at 1.  it is not safe for concurrent use.
+type segmentLog struct {
+	dir         string
+	segmentSize int64
+	segments    []segment
+	active      *os.File
+	activeSize  int64
+	next        uint64
+}
+
+// openSegmentLog recovers the log in dir and returns every record in it,
+// oldest first.  a torn record at the end of the newest segment, as left
+// by a crash mid write, is cut off; any other record that does not decode,
+// such as one whose checksum does not match, fails with ErrCorruptLog.
+// records start after LSN after, and so do the records appended later.
+func openSegmentLog(dir string, segmentSize int64, after uint64) (*segmentLog, []record, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+
+	l := &segmentLog{
+		dir:         dir,
+		segmentSize: segmentSize,
+		next:        after + 1,
+	}
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	records := make([]record, 0)
+	for i, s := range segments {
+		// a crash may lose the records at or before the checkpoint that were
+		// not synced yet, but never any after it
+		if s.base > max(l.next, after+1) || (i > 0 && s.base < l.next) {
+			return nil, nil, fmt.Errorf("%w: %s starts at %d, want %d", ErrCorruptLog, s.path, s.base, l.next)
+		}
+
+		msgs, size, err := readSegment(s.path)
+		switch {
+		case err == nil:
+		case errors.Is(err, io.ErrUnexpectedEOF) && i == len(segments)-1:
+			// only the newest segment is written to, so only it is torn
+			if err := os.Truncate(s.path, size); err != nil {
+				return nil, nil, err
+			}
+		default:
+			return nil, nil, fmt.Errorf("%w: %s: %v", ErrCorruptLog, s.path, err)
+		}
+
+		for j, msg := range msgs {
+			records = append(records, record{lsn: s.base + uint64(j), msg: msg})
+		}
+
+		s.count = uint64(len(msgs))
+		segments[i] = s
+		l.next = s.base + s.count
+		l.activeSize = size
+	}
+
+	l.segments = segments
+
+	// appends carry on after the checkpoint, so that a record is never
+	// written under an LSN that counts as acknowledged
+	if len(l.segments) == 0 || l.next <= after {
+		l.next = max(l.next, after+1)
+		return l, records, l.roll()
+	}
+
+	active, err := os.OpenFile(l.segments[len(l.segments)-1].path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	l.active = active
+
+	return l, records, nil
+}
+
+// append writes msg to the active segment and returns its LSN.  it starts
+// a new segment once the active one is full.
+func (l *segmentLog) append(msg domain.Message) (uint64, error) {
+	frame, err := codec.Marshal(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	if l.activeSize > 0 && l.activeSize+int64(len(frame)) > l.segmentSize {
+		if err := l.roll(); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := l.active.Write(frame); err != nil {
+		// do not leave part of a frame behind
+		_ = l.active.Truncate(l.activeSize)
+		return 0, err
+	}
+
+	lsn := l.next
+	l.next++
+	l.activeSize += int64(len(frame))
+	l.segments[len(l.segments)-1].count++
+
+	return lsn, nil
+}
+
+// roll seals the active segment and starts a new one at the next LSN.
+func (l *segmentLog) roll() error {
+	if l.active != nil {
+		if err := l.active.Sync(); err != nil {
+			return err
+		}
+		if err := l.active.Close(); err != nil {
+			return err
+		}
+	}
+
+	s := segment{
+		base: l.next,
+		path: filepath.Join(l.dir, fmt.Sprintf("%020d%s", l.next, segmentExt)),
+	}
+
+	active, err := os.OpenFile(s.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	l.active = active
+	l.activeSize = 0
+	l.segments = append(l.segments, s)
+
+	return syncDir(l.dir)
+}
+
+func (l *segmentLog) sync() error {
+	return l.active.Sync()
+}
+
+// compact removes the sealed segments whose records are all at or before
+// LSN acked.
+func (l *segmentLog) compact(acked uint64) error {
+	for len(l.segments) > 1 {
+		s := l.segments[0]
+		if s.count > 0 && s.last() > acked {
+			return nil
+		}
+		if err := os.Remove(s.path); err != nil {
+			return err
+		}
+		l.segments = l.segments[1:]
+	}
+	return nil
+}
+
+func (l *segmentLog) close() error {
+	if err := l.active.Sync(); err != nil {
+		_ = l.active.Close()
+		return err
+	}
+	return l.active.Close()
+}
+
+// listSegments returns the segments in dir ordered by base LSN.
+func listSegments(dir string) ([]segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]segment, 0)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		segments = append(segments, segment{base: base, path: filepath.Join(dir, name)})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].base < segments[j].base
+	})
+
+	return segments, nil
+}
+
+// readSegment decodes every frame of the segment at path.  it returns the
+// size of the frames it decoded along with the error that stopped it.
+func readSegment(path string) ([]domain.Message, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var (
+		cr   = &countingReader{r: bufio.NewReader(f)}
+		msgs = make([]domain.Message, 0)
+		size int64
+	)
+
+	for {
+		msg, err := codec.Decode(cr)
+		if errors.Is(err, io.EOF) {
+			return msgs, size, nil
+		}
+		if err != nil {
+			return msgs, size, err
+		}
+
+		msgs = append(msgs, *msg)
+		size = cr.n
+	}
+}
+
+// readCheckpoint returns the LSN stored in the checkpoint in dir, or zero
+// if there is none.
+func readCheckpoint(dir string) (uint64, error) {
+	b, err := os.ReadFile(filepath.Join(dir, checkpointName))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if len(b) != checkpointSize || crc32.ChecksumIEEE(b[:8]) != binary.BigEndian.Uint32(b[8:]) {
+		return 0, ErrCorruptCheckpoint
+	}
+
+	return binary.BigEndian.Uint64(b[:8]), nil
+}
+
+// writeCheckpoint replaces the checkpoint in dir with lsn.  the checkpoint
+// is renamed into place, so a crash leaves either the old or the new one.
+func writeCheckpoint(dir string, lsn uint64) error {
+	b := binary.BigEndian.AppendUint64(make([]byte, 0, checkpointSize), lsn)
+	b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+
+	tmp := filepath.Join(dir, checkpointName+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(dir, checkpointName)); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// syncDir makes the entries of dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func max(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
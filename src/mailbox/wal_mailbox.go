@@ -0,0 +1,333 @@
+package mailbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mstreet3/message-relayer/domain"
+)
+
+// SyncPolicy is when the write-ahead log is flushed to disk.
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes the log on every Add.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval flushes the log once per WALConfig.SyncInterval.
+	SyncInterval
+	// SyncNever leaves flushing the log to the operating system.
+	SyncNever
+)
+
+const (
+	DefaultSegmentSize  = 4 << 20
+	DefaultSyncInterval = 100 * time.Millisecond
+)
+
+var ErrMailboxClosed = errors.New("mailbox: closed")
+
+type WALConfig struct {
+	// Dir holds the segments and the checkpoint of the log.
+	Dir string
+	// SegmentSize is the size at which a new segment is started.
+	SegmentSize int64
+	Sync        SyncPolicy
+	// SyncInterval is how often the log is flushed with SyncInterval.
+	SyncInterval time.Duration
+}
+
+func (cfg WALConfig) withDefaults() WALConfig {
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = DefaultSegmentSize
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = DefaultSyncInterval
+	}
+	return cfg
+}
+
+// WALMailbox is a mailbox that appends every message to a write-ahead log
+// on disk before queueing it.  a message is acknowledged once the receiver
+// of Empty takes it, and the checkpoint of the last acknowledged message
+// is written when Empty is done.  opening the mailbox again replays every
+// message after the checkpoint, so messages are delivered at least once
+// across a crash.  segments that only hold acknowledged messages are
+// removed.  messages are emptied oldest first.
+type WALMailbox struct {
+	mu       sync.Mutex
+	draining sync.Mutex
+	cfg      WALConfig
+	log      *segmentLog
+	pending  []record
+	ready    signal
+	acked    uint64
+	seqs     map[domain.MessageType]uint64
+	err      error
+	closed   bool
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+var _ Mailbox[domain.Message] = (*WALMailbox)(nil)
+
+// OpenWALMailbox opens the log in cfg.Dir, creating it if needed, and
+// queues every message that was not acknowledged.
+func OpenWALMailbox(cfg WALConfig) (*WALMailbox, error) {
+	cfg = cfg.withDefaults()
+
+	acked, err := readCheckpoint(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	log, records, err := openSegmentLog(cfg.Dir, cfg.SegmentSize, acked)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WALMailbox{
+		cfg:     cfg,
+		log:     log,
+		pending: make([]record, 0, len(records)),
+		ready:   newSignal(),
+		acked:   acked,
+		seqs:    make(map[domain.MessageType]uint64),
+		stop:    make(chan struct{}),
+	}
+
+	for _, r := range records {
+		w.sequenced(r.msg)
+		if r.lsn > acked {
+			w.pending = append(w.pending, r)
+		}
+	}
+	if len(w.pending) > 0 {
+		w.ready.notify()
+	}
+
+	if cfg.Sync == SyncInterval {
+		w.wg.Add(1)
+		go w.syncEvery(cfg.SyncInterval)
+	}
+
+	return w, nil
+}
+
+// Add appends msg to the log and queues it.  a message that cannot be
+// written is not queued; Err reports the first such failure.
+func (w *WALMailbox) Add(msg domain.Message) {
+	if err := w.Append(msg); err != nil {
+		w.mu.Lock()
+		w.fail(err)
+		w.mu.Unlock()
+	}
+}
+
+// Append is Add but returns the error a message could not be written with,
+// or ErrMailboxClosed after Close.  only messages that were written are
+// queued.
+func (w *WALMailbox) Append(msg domain.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrMailboxClosed
+	}
+
+	lsn, err := w.log.append(msg)
+	if err == nil && w.cfg.Sync == SyncAlways {
+		err = w.log.sync()
+	}
+	if err != nil {
+		return err
+	}
+
+	w.pending = append(w.pending, record{lsn: lsn, msg: msg})
+	w.sequenced(msg)
+	w.ready.notify()
+	return nil
+}
+
+// Empty puts every queued message onto a channel, oldest first.  the
+// channel is unbuffered so that a message is only acknowledged once the
+// receiver takes it.  messages that are not taken before ctx is done stay
+// queued.
+func (w *WALMailbox) Empty(ctx context.Context) <-chan domain.Message {
+	msgCh := make(chan domain.Message)
+
+	go func() {
+		defer close(msgCh)
+
+		// one Empty at a time, so acknowledgements stay in order
+		w.draining.Lock()
+		defer w.draining.Unlock()
+
+		var (
+			batch = w.take()
+			taken = 0
+		)
+
+	send:
+		for _, r := range batch {
+			select {
+			case <-ctx.Done():
+				break send
+			case msgCh <- r.msg:
+				taken++
+			}
+		}
+
+		w.ack(batch[:taken], batch[taken:])
+	}()
+
+	return msgCh
+}
+
+// Ready receives a value once a message is added, or right after opening
+// if messages were replayed.
+func (w *WALMailbox) Ready() <-chan struct{} {
+	return w.ready
+}
+
+// Len returns the number of queued messages.
+func (w *WALMailbox) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.pending)
+}
+
+// Checkpoint returns the LSN of the last acknowledged message.
+func (w *WALMailbox) Checkpoint() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.acked
+}
+
+// Sequences returns the highest sequence number of each message type in
+// the log, so that a relayer reading into the mailbox after a restart
+// numbers its messages after the ones it replays.
+func (w *WALMailbox) Sequences() map[domain.MessageType]uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	seqs := make(map[domain.MessageType]uint64, len(w.seqs))
+	for mt, seq := range w.seqs {
+		seqs[mt] = seq
+	}
+	return seqs
+}
+
+// Err returns the first error the mailbox failed to write the log with,
+// including a message added after Close.
+func (w *WALMailbox) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.err
+}
+
+// Close flushes the log and writes the checkpoint.  messages that are still
+// queued are replayed by the next OpenWALMailbox.
+func (w *WALMailbox) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrMailboxClosed
+	}
+	w.closed = true
+	close(w.stop)
+	w.mu.Unlock()
+
+	w.wg.Wait()
+
+	// wait for an Empty in progress to acknowledge what it sent
+	w.draining.Lock()
+	defer w.draining.Unlock()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := writeCheckpoint(w.cfg.Dir, w.acked)
+	if cerr := w.log.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (w *WALMailbox) take() []record {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	batch := w.pending
+	w.pending = make([]record, 0)
+	return batch
+}
+
+// ack moves the checkpoint past the taken records and queues the untaken
+// ones again ahead of any message added since they were taken.
+func (w *WALMailbox) ack(taken, untaken []record) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending = append(untaken, w.pending...)
+
+	acked := w.acked
+	for _, r := range taken {
+		if r.lsn > acked {
+			acked = r.lsn
+		}
+	}
+
+	if acked == w.acked || w.closed {
+		w.acked = acked
+		return
+	}
+	w.acked = acked
+
+	if err := writeCheckpoint(w.cfg.Dir, acked); err != nil {
+		w.fail(err)
+		return
+	}
+
+	if err := w.log.compact(acked); err != nil {
+		w.fail(err)
+	}
+}
+
+// sequenced records the sequence number of a message in the log.
+func (w *WALMailbox) sequenced(msg domain.Message) {
+	if msg.Sequence > w.seqs[msg.Type()] {
+		w.seqs[msg.Type()] = msg.Sequence
+	}
+}
+
+func (w *WALMailbox) syncEvery(d time.Duration) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if err := w.log.sync(); err != nil {
+				w.fail(err)
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// fail records the first error.  the caller must hold the lock.
+func (w *WALMailbox) fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
@@ -0,0 +1,407 @@
+package mailbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mstreet3/message-relayer/codec"
+	"github.com/mstreet3/message-relayer/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func openWAL(t *testing.T, cfg WALConfig) *WALMailbox {
+	t.Helper()
+	w, err := OpenWALMailbox(cfg)
+	require.NoError(t, err)
+	return w
+}
+
+// crash closes the log without writing the checkpoint, as if the process
+// died.
+func crash(t *testing.T, w *WALMailbox) {
+	t.Helper()
+	require.NoError(t, w.log.active.Close())
+}
+
+func addN(w *WALMailbox, from, to int) {
+	for i := from; i < to; i++ {
+		w.Add(domain.NewMessage(domain.StartNewRound, []byte(fmt.Sprint(i))))
+	}
+}
+
+func payloads(msgs []domain.Message) []string {
+	got := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		got = append(got, string(msg.Data))
+	}
+	return got
+}
+
+func frameSize(t *testing.T, msg domain.Message) int {
+	t.Helper()
+	frame, err := codec.Marshal(msg)
+	require.NoError(t, err)
+	return len(frame)
+}
+
+func segmentFiles(t *testing.T, dir string) []segment {
+	t.Helper()
+	segments, err := listSegments(dir)
+	require.NoError(t, err)
+	return segments
+}
+
+func Test_WALMailbox_empties_oldest_first(t *testing.T) {
+	w := openWAL(t, WALConfig{Dir: t.TempDir()})
+	defer w.Close()
+
+	addN(w, 0, 3)
+
+	require.Equal(t, []string{"0", "1", "2"}, payloads(drain(context.Background(), w)))
+	require.Equal(t, 0, w.Len())
+	require.Equal(t, uint64(3), w.Checkpoint())
+	require.NoError(t, w.Err())
+}
+
+func Test_WALMailbox_replays_unacknowledged_messages_after_crash(t *testing.T) {
+	dir := t.TempDir()
+
+	w := openWAL(t, WALConfig{Dir: dir})
+	addN(w, 0, 2)
+	require.Len(t, drain(context.Background(), w), 2)
+	addN(w, 2, 5)
+	crash(t, w)
+
+	w = openWAL(t, WALConfig{Dir: dir})
+	defer w.Close()
+
+	require.Equal(t, 3, w.Len())
+	require.Equal(t, []string{"2", "3", "4"}, payloads(drain(context.Background(), w)))
+}
+
+func Test_WALMailbox_replays_ids_and_sequences(t *testing.T) {
+	dir := t.TempDir()
+
+	msg := domain.NewMessage(domain.StartNewRound, []byte("round"))
+	msg.ID = "id"
+	msg.Sequence = 7
+
+	w := openWAL(t, WALConfig{Dir: dir})
+	w.Add(msg)
+	crash(t, w)
+
+	w = openWAL(t, WALConfig{Dir: dir})
+	defer w.Close()
+
+	require.Equal(t, []domain.Message{msg}, drain(context.Background(), w))
+}
+
+func Test_WALMailbox_knows_the_highest_sequences_in_the_log(t *testing.T) {
+	dir := t.TempDir()
+
+	w := openWAL(t, WALConfig{Dir: dir})
+	for _, seq := range []uint64{1, 2, 3} {
+		msg := domain.NewMessage(domain.StartNewRound, nil)
+		msg.Sequence = seq
+		w.Add(msg)
+	}
+	msg := domain.NewMessage(domain.ReceivedAnswer, nil)
+	msg.Sequence = 5
+	w.Add(msg)
+	drain(context.Background(), w)
+	require.NoError(t, w.Close())
+
+	// taken messages still count, as they may be replayed after a crash
+	w = openWAL(t, WALConfig{Dir: dir})
+	defer w.Close()
+
+	require.Equal(t, map[domain.MessageType]uint64{
+		domain.StartNewRound:  3,
+		domain.ReceivedAnswer: 5,
+	}, w.Sequences())
+}
+
+func Test_WALMailbox_ready_after_replay(t *testing.T) {
+	dir := t.TempDir()
+
+	w := openWAL(t, WALConfig{Dir: dir})
+	addN(w, 0, 1)
+	<-w.Ready()
+	crash(t, w)
+
+	// replayed messages are ready without another add
+	w = openWAL(t, WALConfig{Dir: dir})
+	defer w.Close()
+
+	<-w.Ready()
+	require.Equal(t, []string{"0"}, payloads(drain(context.Background(), w)))
+}
+
+func Test_WALMailbox_keeps_messages_not_taken(t *testing.T) {
+	dir := t.TempDir()
+
+	w := openWAL(t, WALConfig{Dir: dir})
+	addN(w, 0, 4)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	msgCh := w.Empty(ctx)
+	require.Equal(t, "0", string((<-msgCh).Data))
+	cancel()
+	for range msgCh {
+	}
+
+	// whatever the receiver did not take stays queued, in order
+	require.Equal(t, uint64(1), w.Checkpoint())
+	addN(w, 4, 5)
+	crash(t, w)
+
+	w = openWAL(t, WALConfig{Dir: dir})
+	defer w.Close()
+
+	require.Equal(t, []string{"1", "2", "3", "4"}, payloads(drain(context.Background(), w)))
+}
+
+func Test_WALMailbox_close_keeps_queued_messages(t *testing.T) {
+	dir := t.TempDir()
+
+	w := openWAL(t, WALConfig{Dir: dir, Sync: SyncNever})
+	addN(w, 0, 3)
+	require.Len(t, drain(context.Background(), w), 3)
+	addN(w, 3, 4)
+	require.NoError(t, w.Close())
+	require.ErrorIs(t, w.Close(), ErrMailboxClosed)
+
+	w = openWAL(t, WALConfig{Dir: dir})
+	defer w.Close()
+
+	require.Equal(t, uint64(3), w.Checkpoint())
+	require.Equal(t, []string{"3"}, payloads(drain(context.Background(), w)))
+}
+
+func Test_WALMailbox_recovers_torn_tail(t *testing.T) {
+	var (
+		last  = domain.NewMessage(domain.ReceivedAnswer, []byte("torn"))
+		frame = frameSize(t, last)
+	)
+
+	// cut the last frame at every byte, as a crash mid write would
+	for cut := 1; cut < frame; cut++ {
+		t.Run(fmt.Sprint(cut), func(t *testing.T) {
+			dir := t.TempDir()
+
+			w := openWAL(t, WALConfig{Dir: dir})
+			addN(w, 0, 2)
+			w.Add(last)
+			crash(t, w)
+
+			segments := segmentFiles(t, dir)
+			require.Len(t, segments, 1)
+
+			info, err := os.Stat(segments[0].path)
+			require.NoError(t, err)
+			require.NoError(t, os.Truncate(segments[0].path, info.Size()-int64(cut)))
+
+			w = openWAL(t, WALConfig{Dir: dir})
+			require.Equal(t, 2, w.Len())
+
+			// the log carries on where the torn frame started
+			addN(w, 2, 3)
+			crash(t, w)
+
+			w = openWAL(t, WALConfig{Dir: dir})
+			defer w.Close()
+
+			require.Equal(t, []string{"0", "1", "2"}, payloads(drain(context.Background(), w)))
+		})
+	}
+}
+
+func Test_WALMailbox_appends_after_a_checkpoint_past_the_log(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		frame = int64(frameSize(t, domain.NewMessage(domain.StartNewRound, []byte("0"))))
+	)
+
+	w := openWAL(t, WALConfig{Dir: dir, Sync: SyncNever})
+	addN(w, 0, 3)
+	require.Len(t, drain(context.Background(), w), 3)
+	require.NoError(t, w.Close())
+
+	// the crash loses acknowledged records that were never synced
+	segments := segmentFiles(t, dir)
+	require.Len(t, segments, 1)
+	require.NoError(t, os.Truncate(segments[0].path, frame))
+
+	w = openWAL(t, WALConfig{Dir: dir})
+	require.Equal(t, 0, w.Len())
+	addN(w, 3, 4)
+	crash(t, w)
+
+	// the new record is not taken for an acknowledged one
+	w = openWAL(t, WALConfig{Dir: dir})
+	defer w.Close()
+
+	require.Equal(t, 1, w.Len())
+	require.Equal(t, []string{"3"}, payloads(drain(context.Background(), w)))
+	require.Equal(t, uint64(4), w.Checkpoint())
+}
+
+func Test_WALMailbox_rejects_log_starting_past_the_checkpoint(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		frame = int64(frameSize(t, domain.NewMessage(domain.StartNewRound, []byte("0"))))
+	)
+
+	w := openWAL(t, WALConfig{Dir: dir, SegmentSize: frame})
+	addN(w, 0, 3)
+	crash(t, w)
+
+	// the records after the checkpoint in the first segment are gone
+	segments := segmentFiles(t, dir)
+	require.Len(t, segments, 3)
+	require.NoError(t, os.Remove(segments[0].path))
+
+	_, err := OpenWALMailbox(WALConfig{Dir: dir, SegmentSize: frame})
+	require.ErrorIs(t, err, ErrCorruptLog)
+}
+
+func Test_WALMailbox_rejects_corrupt_tail_record(t *testing.T) {
+	dir := t.TempDir()
+
+	w := openWAL(t, WALConfig{Dir: dir})
+	addN(w, 0, 3)
+	crash(t, w)
+
+	segments := segmentFiles(t, dir)
+	require.Len(t, segments, 1)
+
+	// flip the last payload byte, ahead of the checksum
+	b, err := os.ReadFile(segments[0].path)
+	require.NoError(t, err)
+	b[len(b)-5] ^= 1
+	require.NoError(t, os.WriteFile(segments[0].path, b, 0o644))
+
+	_, err = OpenWALMailbox(WALConfig{Dir: dir})
+	require.ErrorIs(t, err, ErrCorruptLog)
+
+	// the corrupt record is not cut off
+	info, err := os.Stat(segments[0].path)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(b)), info.Size())
+}
+
+func Test_WALMailbox_does_not_queue_unwritten_messages(t *testing.T) {
+	w := openWAL(t, WALConfig{Dir: t.TempDir()})
+
+	msg := domain.NewMessage(domain.StartNewRound, nil)
+	msg.ID = strings.Repeat("x", codec.MaxIDSize+1)
+
+	require.ErrorIs(t, w.Append(msg), codec.ErrIDTooLarge)
+	require.Equal(t, 0, w.Len())
+
+	w.Add(msg)
+	require.Equal(t, 0, w.Len())
+	require.ErrorIs(t, w.Err(), codec.ErrIDTooLarge)
+
+	require.NoError(t, w.Close())
+	require.ErrorIs(t, w.Append(domain.NewMessage(domain.StartNewRound, nil)), ErrMailboxClosed)
+	require.Equal(t, 0, w.Len())
+}
+
+func Test_WALMailbox_rolls_and_compacts_segments(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		frame = int64(frameSize(t, domain.NewMessage(domain.StartNewRound, []byte("0"))))
+		cfg   = WALConfig{Dir: dir, SegmentSize: 2 * frame}
+	)
+
+	w := openWAL(t, cfg)
+	addN(w, 0, 6)
+	require.Len(t, segmentFiles(t, dir), 3)
+
+	require.Len(t, drain(context.Background(), w), 6)
+
+	// the active segment is kept so the log carries on from it
+	segments := segmentFiles(t, dir)
+	require.Len(t, segments, 1)
+	require.Equal(t, uint64(5), segments[0].base)
+
+	addN(w, 6, 7)
+	crash(t, w)
+
+	w = openWAL(t, cfg)
+	defer w.Close()
+
+	require.Equal(t, uint64(6), w.Checkpoint())
+	require.Equal(t, []string{"6"}, payloads(drain(context.Background(), w)))
+	require.Equal(t, uint64(7), w.Checkpoint())
+}
+
+func Test_WALMailbox_rejects_corrupt_sealed_segment(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		frame = int64(frameSize(t, domain.NewMessage(domain.StartNewRound, []byte("0"))))
+	)
+
+	w := openWAL(t, WALConfig{Dir: dir, SegmentSize: frame})
+	addN(w, 0, 3)
+	crash(t, w)
+
+	segments := segmentFiles(t, dir)
+	require.Len(t, segments, 3)
+	require.NoError(t, os.Truncate(segments[0].path, frame-1))
+
+	_, err := OpenWALMailbox(WALConfig{Dir: dir, SegmentSize: frame})
+	require.ErrorIs(t, err, ErrCorruptLog)
+}
+
+func Test_WALMailbox_rejects_missing_segment(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		frame = int64(frameSize(t, domain.NewMessage(domain.StartNewRound, []byte("0"))))
+	)
+
+	w := openWAL(t, WALConfig{Dir: dir, SegmentSize: frame})
+	addN(w, 0, 3)
+	crash(t, w)
+
+	segments := segmentFiles(t, dir)
+	require.NoError(t, os.Remove(segments[1].path))
+
+	_, err := OpenWALMailbox(WALConfig{Dir: dir, SegmentSize: frame})
+	require.ErrorIs(t, err, ErrCorruptLog)
+}
+
+func Test_WALMailbox_rejects_corrupt_checkpoint(t *testing.T) {
+	dir := t.TempDir()
+
+	w := openWAL(t, WALConfig{Dir: dir})
+	addN(w, 0, 1)
+	require.NoError(t, w.Close())
+
+	path := filepath.Join(dir, checkpointName)
+	require.NoError(t, os.WriteFile(path, []byte("garbage"), 0o644))
+
+	_, err := OpenWALMailbox(WALConfig{Dir: dir})
+	require.ErrorIs(t, err, ErrCorruptCheckpoint)
+}
+
+func Test_WALMailbox_syncs_on_interval(t *testing.T) {
+	dir := t.TempDir()
+
+	w := openWAL(t, WALConfig{Dir: dir, Sync: SyncInterval, SyncInterval: time.Millisecond})
+	addN(w, 0, 3)
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Err())
+
+	w = openWAL(t, WALConfig{Dir: dir})
+	defer w.Close()
+
+	require.Equal(t, []string{"0", "1", "2"}, payloads(drain(context.Background(), w)))
+}
@@ -39,11 +39,15 @@ func main() {
 		ns                     = network.NewNetworkSocketStub(responses)
 		om                     = relayer.NewMessageObserverManager()
 		stack                  = lifo.NewLIFOQueue[domain.Message]()
-		mailbox                = mailbox.NewMessageMailbox(1, stack)
-		mr                     = relayer.NewMessageRelayer(ns, mailbox, om)
+		mailbox                = mailbox.NewMessageMailbox(nil, stack)
 		interrupt              = make(chan os.Signal, 1)
 	)
 
+	mr, err := relayer.NewMessageRelayer(ns, mailbox, om)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Notify main of any interruptions
 	signal.Notify(interrupt, os.Interrupt)
 
@@ -56,6 +60,9 @@ func main() {
 	for {
 		select {
 		case <-stopped:
+			if err := mr.Err(); err != nil {
+				log.Printf("relayer terminated: %s", err)
+			}
 			log.Println("app is stopped, goodbye")
 			return
 
@@ -0,0 +1,128 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mstreet3/message-relayer/domain"
+)
+
+var (
+	// ErrReadTimeout is returned by Read when no message arrives in time.  it
+	// is not fatal; the connection stays open.
+	ErrReadTimeout = errors.New("network read timed out")
+
+	errNotConnected = errors.New("not connected")
+)
+
+// connConfig holds the settings that the TCP and websocket readers share.
+type connConfig struct {
+	DialTimeout time.Duration
+	ReadTimeout time.Duration
+}
+
+func (c connConfig) withDefaults() connConfig {
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = time.Second
+	}
+	if c.ReadTimeout <= 0 {
+		c.ReadTimeout = time.Second
+	}
+	return c
+}
+
+// dialFunc connects and reads frames off the connection until closed is
+// closed.  hangUp closes the connection.
+type dialFunc func(closed <-chan struct{}) (frames <-chan NetworkResponse, hangUp func() error, err error)
+
+// connReader hands out the frames of the connection that a reader dialed
+// last.  restarts are serialized, and the lock is not held while dialing,
+// so reads and closes do not wait for a dial.
+type connReader struct {
+	cfg        connConfig
+	restarting sync.Mutex
+	mu         sync.Mutex
+	frames     <-chan NetworkResponse
+	closed     chan struct{}
+	hangUp     func() error
+	restarts   restartSignal
+}
+
+func newConnReader(cfg connConfig) *connReader {
+	return &connReader{
+		cfg: cfg.withDefaults(),
+	}
+}
+
+func (c *connReader) stream(ctx context.Context) <-chan NetworkResponse {
+	return stream(ctx, c.read, &c.restarts)
+}
+
+func (c *connReader) read() (*domain.Message, error) {
+	c.mu.Lock()
+	frames := c.frames
+	c.mu.Unlock()
+
+	if frames == nil {
+		return nil, fatal(errNotConnected)
+	}
+
+	timeout := time.NewTimer(c.cfg.ReadTimeout)
+	defer timeout.Stop()
+
+	select {
+	case res, open := <-frames:
+		if !open {
+			return nil, fatal(errNotConnected)
+		}
+		return res.Message, res.Error
+	case <-timeout.C:
+		return nil, ErrReadTimeout
+	}
+}
+
+// restart closes the current connection, if any, and calls dial once.  the
+// new connection is swapped in under the lock.  retrying a failed dial is
+// left to the caller of Restart, such as the relayer's RestartPolicy, so
+// that a single layer owns the backoff and it honours the relayer's context.
+func (c *connReader) restart(dial dialFunc) error {
+	c.restarting.Lock()
+	defer c.restarting.Unlock()
+	defer c.restarts.broadcast()
+
+	_ = c.close()
+
+	closed := make(chan struct{})
+	frames, hangUp, err := dial(closed)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.frames = frames
+	c.closed = closed
+	c.hangUp = hangUp
+
+	return nil
+}
+
+func (c *connReader) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.hangUp == nil {
+		return nil
+	}
+
+	close(c.closed)
+	err := c.hangUp()
+	c.frames = nil
+	c.closed = nil
+	c.hangUp = nil
+
+	return err
+}
@@ -1,11 +1,22 @@
 package network
 
-import "github.com/mstreet3/message-relayer/domain"
+import (
+	"context"
+
+	"github.com/mstreet3/message-relayer/domain"
+)
 
 type NetworkReader interface {
 	Read() (*domain.Message, error)
 }
 
+// StreamReader pushes messages onto a channel as fast as they arrive.  the
+// channel is closed once ctx is done.  a fatal error does not end the
+// stream: it resumes after the reader is restarted.
+type StreamReader interface {
+	Stream(ctx context.Context) <-chan NetworkResponse
+}
+
 type Restarter interface {
 	Restart() error
 }
@@ -14,3 +25,8 @@ type RestartNetworkReader interface {
 	Restarter
 	NetworkReader
 }
+
+type RestartStreamReader interface {
+	Restarter
+	StreamReader
+}
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -15,6 +16,7 @@ type NetworkResponse struct {
 
 type NetworkSocketStub struct {
 	mu        sync.Mutex
+	restarts  restartSignal
 	Cursor    int
 	Responses []NetworkResponse
 }
@@ -32,9 +34,15 @@ func (n *NetworkSocketStub) Read() (*domain.Message, error) {
 	return nil, errs.FatalSocketError{}
 }
 
+// Stream pushes each response as soon as it is read.
+func (n *NetworkSocketStub) Stream(ctx context.Context) <-chan NetworkResponse {
+	return stream(ctx, n.Read, &n.restarts)
+}
+
 func (n *NetworkSocketStub) Restart() error {
 	n.mu.Lock()
 	defer n.mu.Unlock()
+	defer n.restarts.broadcast()
 
 	n.Cursor = 0
 	return nil
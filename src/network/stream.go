@@ -0,0 +1,79 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/mstreet3/message-relayer/domain"
+	"github.com/mstreet3/message-relayer/errs"
+)
+
+// restartSignal lets streams wait for the next Restart of their reader.
+type restartSignal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// wait returns a channel that is closed by the next broadcast.
+func (s *restartSignal) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ch == nil {
+		s.ch = make(chan struct{})
+	}
+	return s.ch
+}
+
+func (s *restartSignal) broadcast() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ch != nil {
+		close(s.ch)
+		s.ch = nil
+	}
+}
+
+// stream forwards the results of read onto a channel until ctx is done.
+// read timeouts are skipped, and after a fatal error the stream waits for
+// the next restart rather than spinning on a dead connection.
+func stream(ctx context.Context, read func() (*domain.Message, error), restarts *restartSignal) <-chan NetworkResponse {
+	responses := make(chan NetworkResponse)
+
+	go func() {
+		defer close(responses)
+		for {
+			var (
+				restarted = restarts.wait()
+				msg, err  = read()
+			)
+
+			if errors.Is(err, ErrReadTimeout) {
+				select {
+				case <-ctx.Done():
+					return
+				default:
+					continue
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case responses <- NetworkResponse{Message: msg, Error: err}:
+			}
+
+			if errors.Is(err, errs.FatalSocketError{}) {
+				select {
+				case <-ctx.Done():
+					return
+				case <-restarted:
+				}
+			}
+		}
+	}()
+
+	return responses
+}
@@ -0,0 +1,55 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mstreet3/message-relayer/domain"
+	"github.com/mstreet3/message-relayer/errs"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Stream_waits_for_restart_after_fatal_error(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		msg         = domain.NewMessage(domain.StartNewRound, nil)
+		stub        = NewNetworkSocketStub([]NetworkResponse{{Message: &msg}})
+		responses   = stub.(StreamReader).Stream(ctx)
+	)
+	defer cancel()
+
+	res := <-responses
+	require.NoError(t, res.Error)
+	require.Equal(t, domain.StartNewRound, res.Message.Type())
+
+	// the stub is exhausted
+	res = <-responses
+	require.ErrorIs(t, res.Error, errs.FatalSocketError{})
+
+	select {
+	case res := <-responses:
+		t.Fatalf("stream did not wait for a restart: %v", res)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	require.NoError(t, stub.Restart())
+
+	res = <-responses
+	require.NoError(t, res.Error)
+	require.Equal(t, domain.StartNewRound, res.Message.Type())
+}
+
+func Test_Stream_closes_when_context_is_done(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stub := NewNetworkSocketStub(nil)
+
+	responses := stub.(StreamReader).Stream(ctx)
+	res := <-responses
+	require.ErrorIs(t, res.Error, errs.FatalSocketError{})
+
+	cancel()
+
+	_, open := <-responses
+	require.False(t, open)
+}
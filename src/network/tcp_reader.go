@@ -0,0 +1,124 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mstreet3/message-relayer/codec"
+	"github.com/mstreet3/message-relayer/domain"
+	"github.com/mstreet3/message-relayer/errs"
+)
+
+// TCPConfig configures a TCPNetworkReader.  zero values take the defaults
+// noted on each field.
+type TCPConfig struct {
+	Addr        string        // host:port to dial
+	DialTimeout time.Duration // per dial attempt, default 1s
+	ReadTimeout time.Duration // per Read, default 1s
+}
+
+func (c TCPConfig) conn() connConfig {
+	return connConfig{
+		DialTimeout: c.DialTimeout,
+		ReadTimeout: c.ReadTimeout,
+	}
+}
+
+// TCPNetworkReader reads codec frames from a TCP endpoint.  every frame
+// header carries its payload length, so the stream is split into messages
+// without any further delimiting.
+//
+// the reader does not dial on construction: the first Read reports a
+// FatalSocketError, which prompts the relayer to Restart and connect.  any
+// disconnect or corrupt frame closes the connection and is reported as a
+// FatalSocketError as well.
+type TCPNetworkReader struct {
+	addr string
+	conn *connReader
+}
+
+var (
+	_ RestartNetworkReader = (*TCPNetworkReader)(nil)
+	_ RestartStreamReader  = (*TCPNetworkReader)(nil)
+)
+
+func NewTCPNetworkReader(cfg TCPConfig) *TCPNetworkReader {
+	return &TCPNetworkReader{
+		addr: cfg.Addr,
+		conn: newConnReader(cfg.conn()),
+	}
+}
+
+// Stream pushes each message as soon as it is read off the connection.
+func (r *TCPNetworkReader) Stream(ctx context.Context) <-chan NetworkResponse {
+	return r.conn.stream(ctx)
+}
+
+func (r *TCPNetworkReader) Read() (*domain.Message, error) {
+	return r.conn.read()
+}
+
+// Restart closes the current connection, if any, and dials once.  a failed
+// dial is retried by the relayer's RestartPolicy, not by the reader.  Read
+// and Close do not wait for the dial.
+func (r *TCPNetworkReader) Restart() error {
+	err := r.conn.restart(func(closed <-chan struct{}) (<-chan NetworkResponse, func() error, error) {
+		conn, err := net.DialTimeout("tcp", r.addr, r.conn.cfg.DialTimeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		return readFrames(conn, closed), conn.Close, nil
+	})
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", r.addr, err)
+	}
+
+	return nil
+}
+
+// Close closes the current connection.  the reader may be restarted.
+func (r *TCPNetworkReader) Close() error {
+	return r.conn.close()
+}
+
+// readFrames decodes frames from conn until it fails or closed is closed.
+// a failure is delivered as a final fatal response before the channel is
+// closed.
+func readFrames(conn net.Conn, closed <-chan struct{}) <-chan NetworkResponse {
+	var (
+		frames = make(chan NetworkResponse)
+		br     = bufio.NewReader(conn)
+		send   = func(res NetworkResponse) bool {
+			select {
+			case <-closed:
+				return false
+			case frames <- res:
+				return true
+			}
+		}
+	)
+
+	go func() {
+		defer close(frames)
+		for {
+			msg, err := codec.Decode(br)
+			if err != nil {
+				_ = conn.Close()
+				send(NetworkResponse{Error: fatal(err)})
+				return
+			}
+			if !send(NetworkResponse{Message: msg}) {
+				return
+			}
+		}
+	}()
+
+	return frames
+}
+
+func fatal(cause error) error {
+	return fmt.Errorf("%w: %v", errs.FatalSocketError{}, cause)
+}
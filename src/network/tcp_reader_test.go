@@ -0,0 +1,196 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mstreet3/message-relayer/codec"
+	"github.com/mstreet3/message-relayer/domain"
+	"github.com/mstreet3/message-relayer/errs"
+	"github.com/stretchr/testify/require"
+)
+
+// serve accepts connections on l and hands each one to handle in order.
+func serve(t *testing.T, l net.Listener, handle ...func(net.Conn)) {
+	t.Helper()
+	go func() {
+		for _, h := range handle {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			h(conn)
+		}
+	}()
+}
+
+func writeFrames(msgs ...domain.Message) func(net.Conn) {
+	return func(conn net.Conn) {
+		defer conn.Close()
+		for _, msg := range msgs {
+			if err := codec.Encode(conn, msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func Test_TCPNetworkReader_is_fatal_before_restart(t *testing.T) {
+	r := NewTCPNetworkReader(TCPConfig{Addr: "127.0.0.1:0"})
+
+	_, err := r.Read()
+
+	require.ErrorIs(t, err, errs.FatalSocketError{})
+}
+
+func Test_TCPNetworkReader_reads_frames(t *testing.T) {
+	var (
+		l    = listen(t)
+		msgs = []domain.Message{
+			domain.NewMessage(domain.StartNewRound, []byte("round")),
+			domain.NewMessage(domain.ReceivedAnswer, []byte("answer")),
+		}
+		r = NewTCPNetworkReader(TCPConfig{Addr: l.Addr().String()})
+	)
+	defer r.Close()
+
+	serve(t, l, writeFrames(msgs...))
+	require.NoError(t, r.Restart())
+
+	for _, want := range msgs {
+		got, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, want.Type(), got.Type())
+		require.Equal(t, want.Data, got.Data)
+	}
+
+	// the server hung up after its last frame
+	_, err := r.Read()
+	require.ErrorIs(t, err, errs.FatalSocketError{})
+}
+
+func Test_TCPNetworkReader_restart_redials(t *testing.T) {
+	var (
+		l = listen(t)
+		r = NewTCPNetworkReader(TCPConfig{Addr: l.Addr().String()})
+	)
+	defer r.Close()
+
+	serve(t, l,
+		writeFrames(domain.NewMessage(domain.StartNewRound, []byte("first"))),
+		writeFrames(domain.NewMessage(domain.StartNewRound, []byte("second"))),
+	)
+
+	require.NoError(t, r.Restart())
+	msg, err := r.Read()
+	require.NoError(t, err)
+	require.Equal(t, []byte("first"), msg.Data)
+
+	_, err = r.Read()
+	require.ErrorIs(t, err, errs.FatalSocketError{})
+
+	require.NoError(t, r.Restart())
+	msg, err = r.Read()
+	require.NoError(t, err)
+	require.Equal(t, []byte("second"), msg.Data)
+}
+
+func Test_TCPNetworkReader_corrupt_frame_is_fatal(t *testing.T) {
+	var (
+		l = listen(t)
+		r = NewTCPNetworkReader(TCPConfig{Addr: l.Addr().String()})
+	)
+	defer r.Close()
+
+	serve(t, l, func(conn net.Conn) {
+		defer conn.Close()
+		frame, _ := codec.Marshal(domain.NewMessage(domain.StartNewRound, []byte("payload")))
+		frame[len(frame)-1] ^= 1
+		_, _ = conn.Write(frame)
+	})
+
+	require.NoError(t, r.Restart())
+
+	_, err := r.Read()
+	require.ErrorIs(t, err, errs.FatalSocketError{})
+	require.Contains(t, err.Error(), codec.ErrChecksumMismatch.Error())
+}
+
+func Test_TCPNetworkReader_read_timeout_is_not_fatal(t *testing.T) {
+	var (
+		l       = listen(t)
+		release = make(chan struct{})
+		r       = NewTCPNetworkReader(TCPConfig{
+			Addr:        l.Addr().String(),
+			ReadTimeout: 20 * time.Millisecond,
+		})
+	)
+	defer r.Close()
+
+	serve(t, l, func(conn net.Conn) {
+		<-release
+		writeFrames(domain.NewMessage(domain.ReceivedAnswer, nil))(conn)
+	})
+
+	require.NoError(t, r.Restart())
+
+	_, err := r.Read()
+	require.ErrorIs(t, err, ErrReadTimeout)
+	require.NotErrorIs(t, err, errs.FatalSocketError{})
+
+	close(release)
+	msg, err := r.Read()
+	require.NoError(t, err)
+	require.Equal(t, domain.ReceivedAnswer, msg.Type())
+}
+
+func Test_TCPNetworkReader_restart_dials_once(t *testing.T) {
+	l := listen(t)
+	addr := l.Addr().String()
+	l.Close()
+
+	r := NewTCPNetworkReader(TCPConfig{Addr: addr})
+
+	// the relayer's RestartPolicy retries, so a refused dial fails at once
+	start := time.Now()
+	require.Error(t, r.Restart())
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+
+	_, err := r.Read()
+	require.ErrorIs(t, err, errs.FatalSocketError{})
+}
+
+func Test_TCPNetworkReader_read_and_close_do_not_wait_for_dial(t *testing.T) {
+	var (
+		r       = NewTCPNetworkReader(TCPConfig{Addr: "127.0.0.1:0"})
+		release = make(chan struct{})
+	)
+
+	// a dial that hangs until released
+	restarted := make(chan error)
+	go func() {
+		restarted <- r.conn.restart(func(<-chan struct{}) (<-chan NetworkResponse, func() error, error) {
+			<-release
+			return nil, nil, errNotConnected
+		})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	_, err := r.Read()
+	require.ErrorIs(t, err, errs.FatalSocketError{})
+	require.NoError(t, r.Close())
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+
+	close(release)
+	require.Error(t, <-restarted)
+}
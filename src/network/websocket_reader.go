@@ -0,0 +1,209 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/mstreet3/message-relayer/codec"
+	"github.com/mstreet3/message-relayer/domain"
+)
+
+var errNoMessage = errors.New("decoder returned no message")
+
+// Decoder turns the payload of a single websocket data frame into a message.
+// a decoder that returns neither a message nor an error fails the frame.
+type Decoder func([]byte) (*domain.Message, error)
+
+// WebSocketConfig configures a WebSocketNetworkReader.  zero values take the
+// defaults noted on each field.
+type WebSocketConfig struct {
+	URL          string        // ws:// or wss:// endpoint to dial
+	Header       http.Header   // extra handshake headers
+	Decoder      Decoder       // default codec.Unmarshal
+	DialTimeout  time.Duration // per dial attempt, default 1s
+	ReadTimeout  time.Duration // per Read, default 1s
+	PingInterval time.Duration // between keepalive pings, default 10s
+	PongTimeout  time.Duration // silence tolerated before the peer is dead, default 2 * PingInterval
+}
+
+func (c WebSocketConfig) withDefaults() WebSocketConfig {
+	if c.Decoder == nil {
+		c.Decoder = codec.Unmarshal
+	}
+	if c.PingInterval <= 0 {
+		c.PingInterval = 10 * time.Second
+	}
+	if c.PongTimeout <= 0 {
+		c.PongTimeout = 2 * c.PingInterval
+	}
+	return c
+}
+
+func (c WebSocketConfig) conn() connConfig {
+	return connConfig{
+		DialTimeout: c.DialTimeout,
+		ReadTimeout: c.ReadTimeout,
+	}
+}
+
+// WebSocketNetworkReader reads messages from a websocket stream, decoding
+// every data frame with the configured Decoder.  a frame that fails to
+// decode is reported as a plain error and the stream carries on.
+//
+// the reader pings the peer every PingInterval and expects to hear from it
+// within PongTimeout.  a close frame, a missed pong or any other failure of
+// the connection is reported as a FatalSocketError so that the relayer
+// restarts it.  like the TCPNetworkReader it does not dial on construction.
+type WebSocketNetworkReader struct {
+	cfg  WebSocketConfig
+	conn *connReader
+}
+
+var (
+	_ RestartNetworkReader = (*WebSocketNetworkReader)(nil)
+	_ RestartStreamReader  = (*WebSocketNetworkReader)(nil)
+)
+
+func NewWebSocketNetworkReader(cfg WebSocketConfig) *WebSocketNetworkReader {
+	return &WebSocketNetworkReader{
+		cfg:  cfg.withDefaults(),
+		conn: newConnReader(cfg.conn()),
+	}
+}
+
+// Stream pushes each message as soon as it is read off the connection.
+func (r *WebSocketNetworkReader) Stream(ctx context.Context) <-chan NetworkResponse {
+	return r.conn.stream(ctx)
+}
+
+func (r *WebSocketNetworkReader) Read() (*domain.Message, error) {
+	return r.conn.read()
+}
+
+// Restart closes the current connection, if any, and dials once.  a failed
+// dial is retried by the relayer's RestartPolicy, not by the reader.  Read
+// and Close do not wait for the dial.
+func (r *WebSocketNetworkReader) Restart() error {
+	var (
+		timeout = r.conn.cfg.DialTimeout
+		dialer  = websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: timeout,
+		}
+	)
+
+	err := r.conn.restart(func(closed <-chan struct{}) (<-chan NetworkResponse, func() error, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		conn, resp, err := dialer.DialContext(ctx, r.cfg.URL, r.cfg.Header)
+		if resp != nil && resp.Body != nil {
+			resp.Body.Close()
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		go r.keepalive(conn, closed)
+		return r.readFrames(conn, closed), hangUp(conn), nil
+	})
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", r.cfg.URL, err)
+	}
+
+	return nil
+}
+
+// Close sends a close frame and closes the current connection.  the reader
+// may be restarted.
+func (r *WebSocketNetworkReader) Close() error {
+	return r.conn.close()
+}
+
+// hangUp returns a func that sends a close frame and closes conn.
+func hangUp(conn *websocket.Conn) func() error {
+	return func() error {
+		_ = conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(time.Second),
+		)
+		return conn.Close()
+	}
+}
+
+// readFrames decodes data frames from conn until it fails or closed is
+// closed.  a connection failure is delivered as a final fatal response
+// before the channel is closed.
+func (r *WebSocketNetworkReader) readFrames(conn *websocket.Conn, closed <-chan struct{}) <-chan NetworkResponse {
+	var (
+		frames = make(chan NetworkResponse)
+		alive  = func() error {
+			return conn.SetReadDeadline(time.Now().Add(r.cfg.PongTimeout))
+		}
+		send = func(res NetworkResponse) bool {
+			select {
+			case <-closed:
+				return false
+			case frames <- res:
+				return true
+			}
+		}
+	)
+
+	_ = alive()
+	conn.SetPongHandler(func(string) error {
+		return alive()
+	})
+
+	go func() {
+		defer close(frames)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				_ = conn.Close()
+				send(NetworkResponse{Error: fatal(err)})
+				return
+			}
+
+			_ = alive()
+
+			msg, err := r.cfg.Decoder(data)
+			if err == nil && msg == nil {
+				err = errNoMessage
+			}
+			if err != nil {
+				msg = nil
+				err = fmt.Errorf("decode websocket frame: %w", err)
+			}
+			if !send(NetworkResponse{Message: msg, Error: err}) {
+				return
+			}
+		}
+	}()
+
+	return frames
+}
+
+// keepalive pings the peer until closed is closed or a ping fails.
+func (r *WebSocketNetworkReader) keepalive(conn *websocket.Conn, closed <-chan struct{}) {
+	ticker := time.NewTicker(r.cfg.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+			deadline := time.Now().Add(r.cfg.PingInterval)
+			if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				return
+			}
+		}
+	}
+}
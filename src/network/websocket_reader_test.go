@@ -0,0 +1,209 @@
+package network
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mstreet3/message-relayer/codec"
+	"github.com/mstreet3/message-relayer/domain"
+	"github.com/mstreet3/message-relayer/errs"
+	"github.com/stretchr/testify/require"
+)
+
+// serveWebSocket upgrades every request and hands the connection to handle.
+func serveWebSocket(t *testing.T, handle func(*websocket.Conn)) string {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	}))
+	t.Cleanup(srv.Close)
+
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func writeBinary(t *testing.T, conn *websocket.Conn, msg domain.Message) {
+	t.Helper()
+	frame, err := codec.Marshal(msg)
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, frame))
+}
+
+// discard reads from conn so that control frames are answered.
+func discard(conn *websocket.Conn) {
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func Test_WebSocketNetworkReader_reads_frames(t *testing.T) {
+	url := serveWebSocket(t, func(conn *websocket.Conn) {
+		writeBinary(t, conn, domain.NewMessage(domain.StartNewRound, []byte("round")))
+		writeBinary(t, conn, domain.NewMessage(domain.ReceivedAnswer, []byte("answer")))
+		discard(conn)
+	})
+
+	r := NewWebSocketNetworkReader(WebSocketConfig{URL: url})
+	defer r.Close()
+
+	_, err := r.Read()
+	require.ErrorIs(t, err, errs.FatalSocketError{})
+
+	require.NoError(t, r.Restart())
+
+	msg, err := r.Read()
+	require.NoError(t, err)
+	require.Equal(t, domain.StartNewRound, msg.Type())
+	require.Equal(t, []byte("round"), msg.Data)
+
+	msg, err = r.Read()
+	require.NoError(t, err)
+	require.Equal(t, domain.ReceivedAnswer, msg.Type())
+	require.Equal(t, []byte("answer"), msg.Data)
+}
+
+func Test_WebSocketNetworkReader_uses_decoder(t *testing.T) {
+	var (
+		errBadFrame = errors.New("bad frame")
+		decoder     = func(b []byte) (*domain.Message, error) {
+			mt, ok := domain.LookupMessageType(string(b))
+			if !ok {
+				return nil, errBadFrame
+			}
+			msg := domain.NewMessage(mt, b)
+			return &msg, nil
+		}
+		url = serveWebSocket(t, func(conn *websocket.Conn) {
+			_ = conn.WriteMessage(websocket.TextMessage, []byte("garbage"))
+			_ = conn.WriteMessage(websocket.TextMessage, []byte("ReceivedAnswer"))
+			discard(conn)
+		})
+		r = NewWebSocketNetworkReader(WebSocketConfig{URL: url, Decoder: decoder})
+	)
+	defer r.Close()
+
+	require.NoError(t, r.Restart())
+
+	// a frame that fails to decode does not break the stream
+	_, err := r.Read()
+	require.ErrorIs(t, err, errBadFrame)
+	require.NotErrorIs(t, err, errs.FatalSocketError{})
+
+	msg, err := r.Read()
+	require.NoError(t, err)
+	require.Equal(t, domain.ReceivedAnswer, msg.Type())
+}
+
+func Test_WebSocketNetworkReader_nil_message_is_an_error(t *testing.T) {
+	var (
+		decoder = func(b []byte) (*domain.Message, error) {
+			return nil, nil
+		}
+		url = serveWebSocket(t, func(conn *websocket.Conn) {
+			_ = conn.WriteMessage(websocket.TextMessage, []byte("ignored"))
+			discard(conn)
+		})
+		r = NewWebSocketNetworkReader(WebSocketConfig{URL: url, Decoder: decoder})
+	)
+	defer r.Close()
+
+	require.NoError(t, r.Restart())
+
+	msg, err := r.Read()
+	require.Nil(t, msg)
+	require.ErrorIs(t, err, errNoMessage)
+	require.NotErrorIs(t, err, errs.FatalSocketError{})
+}
+
+func Test_WebSocketNetworkReader_close_frame_is_fatal(t *testing.T) {
+	url := serveWebSocket(t, func(conn *websocket.Conn) {
+		writeBinary(t, conn, domain.NewMessage(domain.StartNewRound, nil))
+		_ = conn.WriteMessage(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "bye"),
+		)
+		discard(conn)
+	})
+
+	r := NewWebSocketNetworkReader(WebSocketConfig{URL: url})
+	defer r.Close()
+
+	require.NoError(t, r.Restart())
+
+	_, err := r.Read()
+	require.NoError(t, err)
+
+	_, err = r.Read()
+	require.ErrorIs(t, err, errs.FatalSocketError{})
+
+	// restart reconnects and the stream starts over
+	require.NoError(t, r.Restart())
+	msg, err := r.Read()
+	require.NoError(t, err)
+	require.Equal(t, domain.StartNewRound, msg.Type())
+}
+
+func Test_WebSocketNetworkReader_keepalive(t *testing.T) {
+	cfg := WebSocketConfig{
+		PingInterval: 10 * time.Millisecond,
+		PongTimeout:  50 * time.Millisecond,
+		ReadTimeout:  200 * time.Millisecond,
+	}
+
+	t.Run("answered pings keep a quiet stream open", func(t *testing.T) {
+		release := make(chan struct{})
+		cfg := cfg
+		cfg.URL = serveWebSocket(t, func(conn *websocket.Conn) {
+			go discard(conn)
+			<-release
+			writeBinary(t, conn, domain.NewMessage(domain.StartNewRound, nil))
+			<-release
+		})
+
+		r := NewWebSocketNetworkReader(cfg)
+		defer r.Close()
+		defer close(release)
+
+		require.NoError(t, r.Restart())
+
+		// stay quiet for several pong timeouts
+		_, err := r.Read()
+		require.ErrorIs(t, err, ErrReadTimeout)
+
+		release <- struct{}{}
+		msg, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, domain.StartNewRound, msg.Type())
+	})
+
+	t.Run("unanswered pings are fatal", func(t *testing.T) {
+		release := make(chan struct{})
+		cfg := cfg
+		cfg.URL = serveWebSocket(t, func(conn *websocket.Conn) {
+			// never reads, so pings go unanswered
+			<-release
+		})
+
+		r := NewWebSocketNetworkReader(cfg)
+		defer r.Close()
+		defer close(release)
+
+		require.NoError(t, r.Restart())
+
+		_, err := r.Read()
+		require.ErrorIs(t, err, errs.FatalSocketError{})
+	})
+}
@@ -0,0 +1,67 @@
+package fifoqueue
+
+import (
+	"container/list"
+	"sync"
+)
+
+// FIFOQueue pops and empties its items in the order they were pushed.
+// PushFront places an item at the back of the queue.
+type FIFOQueue[T any] struct {
+	mu    sync.Mutex
+	queue *list.List
+}
+
+func NewFIFOQueue[T any]() *FIFOQueue[T] {
+	return &FIFOQueue[T]{
+		mu:    sync.Mutex{},
+		queue: list.New(),
+	}
+}
+
+func (q *FIFOQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.queue.Len()
+}
+
+func (q *FIFOQueue[T]) PushFront(msg T) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.queue.PushBack(&msg)
+}
+
+func (q *FIFOQueue[T]) Pop() (*T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.pop()
+}
+
+func (q *FIFOQueue[T]) pop() (*T, bool) {
+	if e := q.queue.Front(); e != nil {
+		q.queue.Remove(e)
+		return e.Value.(*T), true
+	}
+
+	return nil, false
+}
+
+func (q *FIFOQueue[T]) Empty() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	vals := make([]T, 0, q.queue.Len())
+	for val, ok := q.pop(); ok; val, ok = q.pop() {
+		vals = append(vals, *val)
+	}
+
+	return vals
+}
+
+// OldestFirst reports that the queue empties its oldest item first.
+func (q *FIFOQueue[T]) OldestFirst() bool {
+	return true
+}
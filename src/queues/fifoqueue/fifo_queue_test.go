@@ -0,0 +1,14 @@
+package fifoqueue
+
+import (
+	"testing"
+
+	"github.com/mstreet3/message-relayer/mailbox"
+	"github.com/mstreet3/message-relayer/queues/queuetest"
+)
+
+func Test_FIFOQueue_conformance(t *testing.T) {
+	queuetest.Run(t, func() mailbox.StackEmptier[int] {
+		return NewFIFOQueue[int]()
+	}, queuetest.FIFO)
+}
@@ -3,6 +3,8 @@ package lifoqueue
 import (
 	"testing"
 
+	"github.com/mstreet3/message-relayer/mailbox"
+	"github.com/mstreet3/message-relayer/queues/queuetest"
 	"github.com/stretchr/testify/require"
 )
 
@@ -27,3 +29,9 @@ func Test_queue_is_LIFO(t *testing.T) {
 	require.Equal(t, []byte("last in"), *firstOut)
 	require.Equal(t, []byte("first in"), *lastOut)
 }
+
+func Test_LIFOQueue_conformance(t *testing.T) {
+	queuetest.Run(t, func() mailbox.StackEmptier[int] {
+		return NewLIFOQueue[int]()
+	}, queuetest.LIFO)
+}
@@ -0,0 +1,120 @@
+package priorityqueue
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// Less reports whether a is popped before b.
+type Less[T any] func(a, b T) bool
+
+// PriorityQueue pops and empties its items in the order of a comparator.
+// items the comparator does not order are popped in the order they were
+// pushed.
+type PriorityQueue[T any] struct {
+	mu   sync.Mutex
+	heap *items[T]
+	seq  uint64
+}
+
+func NewPriorityQueue[T any](less Less[T]) *PriorityQueue[T] {
+	return &PriorityQueue[T]{
+		mu:   sync.Mutex{},
+		heap: &items[T]{less: less},
+	}
+}
+
+func (q *PriorityQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.heap.Len()
+}
+
+func (q *PriorityQueue[T]) PushFront(msg T) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	heap.Push(q.heap, item[T]{val: msg, seq: q.seq})
+}
+
+func (q *PriorityQueue[T]) Pop() (*T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.pop()
+}
+
+func (q *PriorityQueue[T]) pop() (*T, bool) {
+	if q.heap.Len() == 0 {
+		return nil, false
+	}
+
+	it := heap.Pop(q.heap).(item[T])
+	return &it.val, true
+}
+
+func (q *PriorityQueue[T]) Empty() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	vals := make([]T, 0, q.heap.Len())
+	for val, ok := q.pop(); ok; val, ok = q.pop() {
+		vals = append(vals, *val)
+	}
+
+	return vals
+}
+
+// OldestFirst reports false: the queue empties its items in the order of
+// the comparator rather than by age.
+func (q *PriorityQueue[T]) OldestFirst() bool {
+	return false
+}
+
+type item[T any] struct {
+	val T
+	seq uint64
+}
+
+// items implements heap.Interface.  the push sequence breaks ties so that
+// the heap is stable.
+type items[T any] struct {
+	less Less[T]
+	vals []item[T]
+}
+
+func (h *items[T]) Len() int {
+	return len(h.vals)
+}
+
+func (h *items[T]) Less(i, j int) bool {
+	a, b := h.vals[i], h.vals[j]
+	if h.less(a.val, b.val) {
+		return true
+	}
+	if h.less(b.val, a.val) {
+		return false
+	}
+	return a.seq < b.seq
+}
+
+func (h *items[T]) Swap(i, j int) {
+	h.vals[i], h.vals[j] = h.vals[j], h.vals[i]
+}
+
+func (h *items[T]) Push(x any) {
+	h.vals = append(h.vals, x.(item[T]))
+}
+
+func (h *items[T]) Pop() any {
+	var (
+		n    = len(h.vals)
+		last = h.vals[n-1]
+		zero item[T]
+	)
+	h.vals[n-1] = zero
+	h.vals = h.vals[:n-1]
+	return last
+}
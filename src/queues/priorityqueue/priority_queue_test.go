@@ -0,0 +1,34 @@
+package priorityqueue
+
+import (
+	"testing"
+
+	"github.com/mstreet3/message-relayer/mailbox"
+	"github.com/mstreet3/message-relayer/queues/queuetest"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PriorityQueue_conformance(t *testing.T) {
+	queuetest.Run(t, func() mailbox.StackEmptier[int] {
+		return NewPriorityQueue(func(a, b int) bool { return a < b })
+	}, queuetest.Ascending)
+}
+
+func Test_PriorityQueue_pops_ties_in_push_order(t *testing.T) {
+	type job struct {
+		priority int
+		name     string
+	}
+
+	q := NewPriorityQueue(func(a, b job) bool { return a.priority < b.priority })
+	for _, j := range []job{{2, "a"}, {1, "b"}, {2, "c"}, {1, "d"}, {2, "e"}} {
+		q.PushFront(j)
+	}
+
+	names := make([]string, 0)
+	for _, j := range q.Empty() {
+		names = append(names, j.name)
+	}
+
+	require.Equal(t, []string{"b", "d", "a", "c", "e"}, names)
+}
@@ -0,0 +1,176 @@
+// Package queuetest checks that a queue behaves as a mailbox expects.
+// every queue in queues runs these tests.
+package queuetest
+
+import (
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/mstreet3/message-relayer/mailbox"
+	"github.com/stretchr/testify/require"
+)
+
+// Order returns the items pushed, in the order a queue pops them.
+type Order func(pushed []int) []int
+
+// LIFO pops the newest item first.
+func LIFO(pushed []int) []int {
+	popped := make([]int, 0, len(pushed))
+	for i := len(pushed) - 1; i >= 0; i-- {
+		popped = append(popped, pushed[i])
+	}
+	return popped
+}
+
+// FIFO pops the oldest item first.
+func FIFO(pushed []int) []int {
+	return append(make([]int, 0, len(pushed)), pushed...)
+}
+
+// Ascending pops the smallest item first.
+func Ascending(pushed []int) []int {
+	popped := FIFO(pushed)
+	sort.Ints(popped)
+	return popped
+}
+
+// Run tests the queues made by newQueue against order.  a queue with a
+// bounded capacity must hold at least 1024 items.
+func Run(t *testing.T, newQueue func() mailbox.StackEmptier[int], order Order) {
+	pushed := []int{3, 1, 4, 10, 5, 9, 2, 6}
+
+	t.Run("pop_empty_queue_returns_nil", func(t *testing.T) {
+		q := newQueue()
+
+		val, ok := q.Pop()
+		require.Nil(t, val)
+		require.False(t, ok)
+		require.Equal(t, 0, q.Len())
+	})
+
+	t.Run("len_counts_items", func(t *testing.T) {
+		q := newQueue()
+		for i, val := range pushed {
+			q.PushFront(val)
+			require.Equal(t, i+1, q.Len())
+		}
+
+		_, _ = q.Pop()
+		require.Equal(t, len(pushed)-1, q.Len())
+	})
+
+	t.Run("pops_in_order", func(t *testing.T) {
+		q := newQueue()
+		for _, val := range pushed {
+			q.PushFront(val)
+		}
+
+		popped := make([]int, 0, len(pushed))
+		for val, ok := q.Pop(); ok; val, ok = q.Pop() {
+			popped = append(popped, *val)
+		}
+
+		require.Equal(t, order(pushed), popped)
+	})
+
+	t.Run("empties_in_order", func(t *testing.T) {
+		q := newQueue()
+		require.Equal(t, []int{}, q.Empty())
+
+		for _, val := range pushed {
+			q.PushFront(val)
+		}
+
+		require.Equal(t, order(pushed), q.Empty())
+		require.Equal(t, 0, q.Len())
+		require.Equal(t, []int{}, q.Empty())
+	})
+
+	t.Run("interleaves_pushes_and_pops", func(t *testing.T) {
+		var (
+			q         = newQueue()
+			first     = pushed[:4]
+			rest      = pushed[4:]
+			want      = order(first)[0]
+			remaining = make([]int, 0, len(pushed))
+			popped    = false
+		)
+
+		for _, val := range first {
+			q.PushFront(val)
+		}
+
+		val, ok := q.Pop()
+		require.True(t, ok)
+		require.Equal(t, want, *val)
+
+		for _, val := range rest {
+			q.PushFront(val)
+		}
+
+		for _, val := range pushed {
+			if val == want && !popped {
+				popped = true
+				continue
+			}
+			remaining = append(remaining, val)
+		}
+
+		require.Equal(t, order(remaining), q.Empty())
+	})
+
+	t.Run("concurrent_pushes_and_pops_keep_every_item", func(t *testing.T) {
+		const (
+			producers = 4
+			perWorker = 200
+			total     = producers * perWorker
+		)
+
+		var (
+			q    = newQueue()
+			wg   sync.WaitGroup
+			mu   sync.Mutex
+			seen = make(map[int]int, total)
+			got  = 0
+		)
+
+		for p := 0; p < producers; p++ {
+			wg.Add(1)
+			go func(p int) {
+				defer wg.Done()
+				for i := 0; i < perWorker; i++ {
+					q.PushFront(p*perWorker + i)
+				}
+			}(p)
+		}
+
+		for c := 0; c < producers; c++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < perWorker; i++ {
+					val, ok := q.Pop()
+					if !ok {
+						continue
+					}
+					mu.Lock()
+					seen[*val]++
+					mu.Unlock()
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		for _, val := range q.Empty() {
+			seen[val]++
+		}
+
+		for val, n := range seen {
+			require.Equal(t, 1, n, "item %d popped %d times", val, n)
+			got++
+		}
+		require.Equal(t, total, got)
+	})
+}
@@ -0,0 +1,145 @@
+package ringbuffer
+
+import (
+	"sync/atomic"
+)
+
+// RingBuffer is a bounded, lock-free queue for many producers and many
+// consumers.  it pops and empties its items in the order they were pushed.
+// once the buffer is full, PushFront overwrites the oldest item.
+//
+// each cell carries a sequence number that tells producers and consumers
+// whose turn it is, as in Dmitry Vyukov's bounded MPMC queue.
+type RingBuffer[T any] struct {
+	cells []cell[T]
+	size  uint64
+	_     [56]byte
+	head  atomic.Uint64 // next position to pop
+	_     [56]byte
+	tail  atomic.Uint64 // next position to push
+	_     [56]byte
+	drops atomic.Uint64
+}
+
+type cell[T any] struct {
+	seq atomic.Uint64
+	val T
+}
+
+// NewRingBuffer returns a ring buffer that holds up to capacity items.
+// capacity below two holds two items, since a cell's sequence number could
+// not tell a full cell from a free one with a single cell.
+func NewRingBuffer[T any](capacity int) *RingBuffer[T] {
+	if capacity < 2 {
+		capacity = 2
+	}
+
+	r := &RingBuffer[T]{
+		cells: make([]cell[T], capacity),
+		size:  uint64(capacity),
+	}
+	for i := range r.cells {
+		r.cells[i].seq.Store(uint64(i))
+	}
+
+	return r
+}
+
+// Len returns the number of items in the buffer.  with concurrent pushes
+// and pops it is a snapshot that may already be stale.
+func (r *RingBuffer[T]) Len() int {
+	for {
+		head := r.head.Load()
+		tail := r.tail.Load()
+		if head != r.head.Load() {
+			continue
+		}
+		if tail < head {
+			return 0
+		}
+		if n := tail - head; n < r.size {
+			return int(n)
+		}
+		return int(r.size)
+	}
+}
+
+// Cap returns the number of items the buffer holds.
+func (r *RingBuffer[T]) Cap() int {
+	return int(r.size)
+}
+
+// Dropped returns the number of items that were overwritten before they
+// were popped.
+func (r *RingBuffer[T]) Dropped() uint64 {
+	return r.drops.Load()
+}
+
+// PushFront places msg at the back of the buffer, overwriting the oldest
+// item if the buffer is full.
+func (r *RingBuffer[T]) PushFront(msg T) {
+	pos := r.tail.Load()
+	for {
+		c := &r.cells[pos%r.size]
+		seq := c.seq.Load()
+
+		switch diff := int64(seq - pos); {
+		case diff == 0:
+			// the cell is free for pos
+			if r.tail.CompareAndSwap(pos, pos+1) {
+				c.val = msg
+				c.seq.Store(pos + 1)
+				return
+			}
+		case diff < 0:
+			// the cell still holds the item a lap behind, so the buffer is
+			// full.  pop the oldest item to make room
+			if _, ok := r.Pop(); ok {
+				r.drops.Add(1)
+			}
+		}
+
+		pos = r.tail.Load()
+	}
+}
+
+// Pop removes and returns the oldest item in the buffer.
+func (r *RingBuffer[T]) Pop() (*T, bool) {
+	pos := r.head.Load()
+	for {
+		c := &r.cells[pos%r.size]
+		seq := c.seq.Load()
+
+		switch diff := int64(seq - (pos + 1)); {
+		case diff == 0:
+			// the cell holds the item at pos
+			if r.head.CompareAndSwap(pos, pos+1) {
+				var zero T
+				val := c.val
+				c.val = zero
+				c.seq.Store(pos + r.size)
+				return &val, true
+			}
+		case diff < 0:
+			// the item at pos is not pushed yet
+			return nil, false
+		}
+
+		pos = r.head.Load()
+	}
+}
+
+// Empty pops every item in the buffer, oldest first.
+func (r *RingBuffer[T]) Empty() []T {
+	vals := make([]T, 0, r.Len())
+	for val, ok := r.Pop(); ok; val, ok = r.Pop() {
+		vals = append(vals, *val)
+	}
+
+	return vals
+}
+
+// OldestFirst reports that the buffer empties its oldest item first.
+func (r *RingBuffer[T]) OldestFirst() bool {
+	return true
+}
@@ -0,0 +1,103 @@
+package ringbuffer
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/mstreet3/message-relayer/mailbox"
+	"github.com/mstreet3/message-relayer/queues/queuetest"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RingBuffer_conformance(t *testing.T) {
+	queuetest.Run(t, func() mailbox.StackEmptier[int] {
+		return NewRingBuffer[int](1024)
+	}, queuetest.FIFO)
+}
+
+func Test_RingBuffer_overwrites_oldest(t *testing.T) {
+	r := NewRingBuffer[int](3)
+	for i := 0; i < 5; i++ {
+		r.PushFront(i)
+	}
+
+	require.Equal(t, 3, r.Len())
+	require.Equal(t, uint64(2), r.Dropped())
+	require.Equal(t, []int{2, 3, 4}, r.Empty())
+
+	// the buffer keeps working after it wraps around
+	r.PushFront(5)
+	require.Equal(t, []int{5}, r.Empty())
+}
+
+func Test_RingBuffer_holds_at_least_two_items(t *testing.T) {
+	r := NewRingBuffer[int](1)
+	r.PushFront(1)
+	r.PushFront(2)
+	r.PushFront(3)
+
+	require.Equal(t, 2, r.Cap())
+	require.Equal(t, []int{2, 3}, r.Empty())
+}
+
+func Test_RingBuffer_concurrent_overwrites_keep_order(t *testing.T) {
+	const (
+		producers = 4
+		perWorker = 2000
+	)
+
+	var (
+		r  = NewRingBuffer[[2]int](8)
+		wg sync.WaitGroup
+		mu sync.Mutex
+		// the last item popped of each producer
+		last = make([]int, producers)
+		got  = 0
+	)
+
+	for p := range last {
+		last[p] = -1
+	}
+
+	pop := func() bool {
+		val, ok := r.Pop()
+		if !ok {
+			return false
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		p, i := val[0], val[1]
+		require.Greater(t, i, last[p], "producer %d out of order", p)
+		last[p] = i
+		got++
+		return true
+	}
+
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				r.PushFront([2]int{p, i})
+			}
+		}(p)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wg.Wait()
+	}()
+
+	for {
+		select {
+		case <-done:
+			for pop() {
+			}
+			require.Equal(t, uint64(producers*perWorker), uint64(got)+r.Dropped())
+			return
+		default:
+			pop()
+		}
+	}
+}
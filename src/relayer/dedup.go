@@ -0,0 +1,168 @@
+package relayer
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mstreet3/message-relayer/domain"
+)
+
+const (
+	DefaultDedupWindow = time.Minute
+	DefaultDedupSize   = 1024
+)
+
+var (
+	ErrInvalidDedupWindow = errors.New("relayer: dedup window must not be negative")
+	ErrInvalidDedupSize   = errors.New("relayer: dedup size must not be negative")
+)
+
+// DedupKey identifies a message for deduplication.  messages with the same
+// key are duplicates.
+type DedupKey func(domain.Message) string
+
+// ContentKey keys a message by a hash of its type, correlation id and
+// data.  the timestamp is left out, since a redelivered message is read at
+// another time.
+func ContentKey(msg domain.Message) string {
+	var (
+		h  = sha256.New()
+		mt [4]byte
+		n  [8]byte
+	)
+
+	binary.BigEndian.PutUint32(mt[:], uint32(msg.Type()))
+	_, _ = h.Write(mt[:])
+
+	// length prefixes keep the correlation id and the data apart
+	binary.BigEndian.PutUint64(n[:], uint64(len(msg.CorrelationID)))
+	_, _ = h.Write(n[:])
+	_, _ = h.Write([]byte(msg.CorrelationID))
+	_, _ = h.Write(msg.Data)
+
+	return string(h.Sum(nil))
+}
+
+// IDKey keys a message by the id it was read with, and a message read
+// without an id by ContentKey.
+func IDKey(msg domain.Message) string {
+	if msg.ID == "" {
+		return ContentKey(msg)
+	}
+	return "id:" + msg.ID
+}
+
+// DedupConfig configures the deduplication of messages read from the
+// network.
+type DedupConfig struct {
+	// Key identifies a message.  defaults to ContentKey.
+	Key DedupKey
+	// Window is how long a key is remembered after it is first seen.
+	// defaults to DefaultDedupWindow.
+	Window time.Duration
+	// Size is the most keys remembered at once; the oldest key is
+	// forgotten first.  defaults to DefaultDedupSize.
+	Size int
+}
+
+// WithDeduplication drops every message read from the network whose key
+// was seen within the window, before it reaches the mailbox.  Duplicates
+// counts the messages dropped.
+func WithDeduplication(cfg DedupConfig) Option {
+	return func(mr *messageRelayer) error {
+		if cfg.Window < 0 {
+			return ErrInvalidDedupWindow
+		}
+		if cfg.Size < 0 {
+			return ErrInvalidDedupSize
+		}
+		mr.dedup = newDedupWindow(cfg)
+		return nil
+	}
+}
+
+// dedupWindow remembers the keys seen within a window of time, up to a
+// bounded number of keys.
+type dedupWindow struct {
+	mu         sync.Mutex
+	key        DedupKey
+	window     time.Duration
+	size       int
+	now        func() time.Time
+	seen       map[string]*list.Element
+	order      *list.List // of *dedupEntry, oldest first
+	suppressed atomic.Uint64
+}
+
+type dedupEntry struct {
+	key  string
+	seen time.Time
+}
+
+func newDedupWindow(cfg DedupConfig) *dedupWindow {
+	if cfg.Key == nil {
+		cfg.Key = ContentKey
+	}
+	if cfg.Window == 0 {
+		cfg.Window = DefaultDedupWindow
+	}
+	if cfg.Size == 0 {
+		cfg.Size = DefaultDedupSize
+	}
+
+	return &dedupWindow{
+		key:    cfg.Key,
+		window: cfg.Window,
+		size:   cfg.Size,
+		now:    time.Now,
+		seen:   make(map[string]*list.Element),
+		order:  list.New(),
+	}
+}
+
+// duplicate reports whether msg was seen within the window and remembers
+// it otherwise.  a key is remembered from when it is first seen, so a
+// duplicate does not extend the window.
+func (d *dedupWindow) duplicate(msg domain.Message) bool {
+	key := d.key(msg)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	d.expire(now)
+
+	if _, ok := d.seen[key]; ok {
+		d.suppressed.Add(1)
+		return true
+	}
+
+	if d.order.Len() >= d.size {
+		d.forget(d.order.Front())
+	}
+	d.seen[key] = d.order.PushBack(&dedupEntry{key: key, seen: now})
+
+	return false
+}
+
+// expire forgets the keys seen before the window.  the caller must hold
+// the lock.
+func (d *dedupWindow) expire(now time.Time) {
+	for e := d.order.Front(); e != nil; e = d.order.Front() {
+		if now.Sub(e.Value.(*dedupEntry).seen) < d.window {
+			return
+		}
+		d.forget(e)
+	}
+}
+
+// forget drops the key of e.  the caller must hold the lock.
+func (d *dedupWindow) forget(e *list.Element) {
+	d.order.Remove(e)
+	delete(d.seen, e.Value.(*dedupEntry).key)
+}
@@ -0,0 +1,154 @@
+package relayer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mstreet3/message-relayer/domain"
+	queue "github.com/mstreet3/message-relayer/mailbox"
+	"github.com/mstreet3/message-relayer/network"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a clock that only moves when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestDedup(cfg DedupConfig) (*dedupWindow, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	d := newDedupWindow(cfg)
+	d.now = clock.Now
+	return d, clock
+}
+
+func msgWithData(d string) domain.Message {
+	return domain.NewMessage(domain.StartNewRound, []byte(d))
+}
+
+func Test_ContentKey_ignores_timestamp(t *testing.T) {
+	a, b := msgWithData("round"), msgWithData("round")
+	a.Timestamp, b.Timestamp = 1, 2
+
+	require.Equal(t, ContentKey(a), ContentKey(b))
+}
+
+func Test_ContentKey_tells_messages_apart(t *testing.T) {
+	var (
+		withID = domain.NewMessage(domain.StartNewRound, []byte("bc"))
+		shift  = domain.NewMessage(domain.StartNewRound, []byte("c"))
+		keys   = map[string]struct{}{}
+	)
+	withID.CorrelationID = "a"
+	shift.CorrelationID = "ab"
+
+	for _, msg := range []domain.Message{
+		msgWithData("round"),
+		domain.NewMessage(domain.ReceivedAnswer, []byte("round")),
+		withID,
+		shift,
+	} {
+		keys[ContentKey(msg)] = struct{}{}
+	}
+
+	require.Len(t, keys, 4)
+}
+
+func Test_IDKey_prefers_id_over_content(t *testing.T) {
+	a, b := msgWithData("first"), msgWithData("second")
+	a.ID, b.ID = "id", "id"
+
+	require.Equal(t, IDKey(a), IDKey(b))
+	require.Equal(t, ContentKey(msgWithData("first")), IDKey(msgWithData("first")))
+}
+
+func Test_dedupWindow_suppresses_duplicates_within_window(t *testing.T) {
+	d, clock := newTestDedup(DedupConfig{Window: time.Second})
+
+	require.False(t, d.duplicate(msgWithData("a")))
+	require.True(t, d.duplicate(msgWithData("a")))
+	require.False(t, d.duplicate(msgWithData("b")))
+
+	// a duplicate does not extend the window
+	clock.Advance(time.Second / 2)
+	require.True(t, d.duplicate(msgWithData("a")))
+	clock.Advance(time.Second / 2)
+	require.False(t, d.duplicate(msgWithData("a")))
+
+	require.Equal(t, uint64(2), d.suppressed.Load())
+}
+
+func Test_dedupWindow_forgets_oldest_key_when_full(t *testing.T) {
+	d, _ := newTestDedup(DedupConfig{Size: 2})
+
+	require.False(t, d.duplicate(msgWithData("a")))
+	require.False(t, d.duplicate(msgWithData("b")))
+	require.False(t, d.duplicate(msgWithData("c")))
+
+	require.True(t, d.duplicate(msgWithData("c")))
+	require.True(t, d.duplicate(msgWithData("b")))
+	require.False(t, d.duplicate(msgWithData("a")))
+}
+
+func Test_dedupWindow_uses_custom_key(t *testing.T) {
+	d, _ := newTestDedup(DedupConfig{Key: func(msg domain.Message) string {
+		return msg.CorrelationID
+	}})
+
+	a, b := msgWithData("first"), msgWithData("second")
+	a.CorrelationID, b.CorrelationID = "id", "id"
+
+	require.False(t, d.duplicate(a))
+	require.True(t, d.duplicate(b))
+}
+
+func Test_WithDeduplication_rejects_negative_values(t *testing.T) {
+	_, err := NewMessageRelayer(nil, nil, nil, WithDeduplication(DedupConfig{Window: -1}))
+	require.ErrorIs(t, err, ErrInvalidDedupWindow)
+
+	_, err = NewMessageRelayer(nil, nil, nil, WithDeduplication(DedupConfig{Size: -1}))
+	require.ErrorIs(t, err, ErrInvalidDedupSize)
+}
+
+func Test_MessageRelayer_drops_redelivered_messages(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		first       = msgWithData("first")
+		second      = msgWithData("second")
+		// the stub rewinds on restart and reads both messages again
+		socket = network.NewNetworkSocketStub([]network.NetworkResponse{
+			{Message: &first},
+			{Message: &second},
+		})
+		mr = newRelayer(t, socket, queue.NewPriorityMailbox(nil),
+			WithReadInterval(time.Millisecond),
+			WithDeduplication(DedupConfig{}),
+		)
+	)
+	defer cancel()
+
+	msgs, _ := mr.SubscribeAll(context.Background(), WithBufferedDelivery(16))
+	terminated := mr.Start(ctx)
+
+	require.Eventually(t, func() bool {
+		return mr.Duplicates() >= 4
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	<-terminated
+
+	got := make([]string, 0)
+	for msg := range msgs {
+		got = append(got, string(msg.Data))
+	}
+	require.Equal(t, []string{"first", "second"}, got)
+}
@@ -0,0 +1,138 @@
+package relayer
+
+import (
+	"sync"
+)
+
+type pushResult int
+
+const (
+	pushed pushResult = iota
+	queueFull
+	queueClosed
+)
+
+// deliveryQueue is a FIFO that hands work to delivery workers: the
+// messages notified to a subscription or the subscriptions waiting on a
+// dispatcher.  a queue with a limit holds at most that many items.
+type deliveryQueue[T any] struct {
+	mu     sync.Mutex
+	items  []T
+	limit  int
+	closed bool
+	ready  chan struct{}
+	space  chan struct{}
+}
+
+// newDeliveryQueue returns a queue of at most limit items, or an unbounded
+// queue for a limit below one.
+func newDeliveryQueue[T any](limit int) *deliveryQueue[T] {
+	return &deliveryQueue[T]{
+		limit: limit,
+		ready: make(chan struct{}, 1),
+		space: make(chan struct{}, 1),
+	}
+}
+
+// push appends item and wakes a waiting worker, unless the queue is full
+// or closed.  it never blocks.
+func (q *deliveryQueue[T]) push(item T) pushResult {
+	return q.add(item, false)
+}
+
+// pushOver is push for an item that is queued even past the limit.
+func (q *deliveryQueue[T]) pushOver(item T) pushResult {
+	return q.add(item, true)
+}
+
+func (q *deliveryQueue[T]) add(item T, overLimit bool) pushResult {
+	q.mu.Lock()
+	switch {
+	case q.closed:
+		q.mu.Unlock()
+		return queueClosed
+	case !overLimit && q.limit > 0 && len(q.items) >= q.limit:
+		q.mu.Unlock()
+		return queueFull
+	}
+
+	q.items = append(q.items, item)
+
+	// pass the room on to the next pusher
+	if q.limit > 0 && len(q.items) < q.limit {
+		wake(q.space)
+	}
+	q.mu.Unlock()
+
+	wake(q.ready)
+	return pushed
+}
+
+// pop removes the oldest item, waiting for one until done is closed.
+func (q *deliveryQueue[T]) pop(done <-chan struct{}) (T, bool) {
+	for {
+		if item, ok := q.tryPop(); ok {
+			return item, true
+		}
+
+		select {
+		case <-done:
+			var zero T
+			return zero, false
+		case <-q.ready:
+		}
+	}
+}
+
+// tryPop removes the oldest item if there is one.
+func (q *deliveryQueue[T]) tryPop() (T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var zero T
+	if len(q.items) == 0 {
+		return zero, false
+	}
+
+	item := q.items[0]
+	q.items[0] = zero
+	q.items = q.items[1:]
+
+	// pass the wake up on to the next worker
+	if len(q.items) > 0 {
+		wake(q.ready)
+	}
+	wake(q.space)
+
+	return item, true
+}
+
+// hasSpace receives a value once an item is removed from a full queue.
+func (q *deliveryQueue[T]) hasSpace() <-chan struct{} {
+	return q.space
+}
+
+// close removes and returns every queued item.  later pushes fail.
+func (q *deliveryQueue[T]) close() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := q.items
+	q.items = nil
+	q.closed = true
+	return items
+}
+
+func (q *deliveryQueue[T]) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.items)
+}
+
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
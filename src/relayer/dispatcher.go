@@ -0,0 +1,111 @@
+package relayer
+
+import (
+	"sync"
+)
+
+// dispatchBatch is the most messages a pool worker delivers to one
+// subscription before it serves the next.
+const dispatchBatch = 16
+
+// the dispatch states of a subscription.  only the goroutine that moved a
+// subscription to dispatchRunning delivers to it.
+const (
+	dispatchIdle int32 = iota
+	dispatchQueued
+	dispatchRunning
+)
+
+// dispatcher delivers the messages of every subscription with a fixed
+// number of workers.  a subscription runs on at most one worker at a time,
+// so its messages are still delivered in order.
+type dispatcher struct {
+	runnable *deliveryQueue[*subscription]
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newDispatcher(workers int) *dispatcher {
+	d := &dispatcher{
+		runnable: newDeliveryQueue[*subscription](0),
+		done:     make(chan struct{}),
+	}
+
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+
+	return d
+}
+
+// schedule queues sub for a worker unless it is queued or running already.
+func (d *dispatcher) schedule(sub *subscription) {
+	if sub.dispatch.CompareAndSwap(dispatchIdle, dispatchQueued) {
+		d.runnable.push(sub)
+	}
+}
+
+// end finishes a closing sub on the caller unless a worker is running it,
+// in which case the worker finishes it.  a subscription that is closing
+// never waits for a free worker.
+func (d *dispatcher) end(sub *subscription) {
+	if sub.dispatch.CompareAndSwap(dispatchIdle, dispatchRunning) ||
+		sub.dispatch.CompareAndSwap(dispatchQueued, dispatchRunning) {
+		d.run(sub)
+	}
+}
+
+func (d *dispatcher) work() {
+	defer d.wg.Done()
+	for {
+		sub, ok := d.runnable.pop(d.done)
+		if !ok {
+			return
+		}
+
+		// a subscription that was ended while queued is skipped
+		if sub.dispatch.CompareAndSwap(dispatchQueued, dispatchRunning) {
+			d.run(sub)
+		}
+	}
+}
+
+// run delivers a batch of messages to sub and schedules it again if it has
+// more work.  a subscriber that blocks is waited on by a goroutine of its
+// own, so it does not hold the worker.
+func (d *dispatcher) run(sub *subscription) {
+	for i := 0; i < dispatchBatch; i++ {
+		waiting, ok := sub.deliverNext()
+		if waiting != nil {
+			// sub stays running, so no worker delivers to it meanwhile
+			d.wg.Add(1)
+			go d.wait(sub, *waiting)
+			return
+		}
+		if !ok {
+			break
+		}
+	}
+
+	// a push or close racing with this check schedules sub itself
+	sub.dispatch.Store(dispatchIdle)
+	if sub.pending() {
+		d.schedule(sub)
+	}
+}
+
+// wait blocks until the subscriber of sub receives dl or its delivery
+// gives up, then carries on delivering to sub.
+func (d *dispatcher) wait(sub *subscription, dl delivery) {
+	defer d.wg.Done()
+
+	sub.observe(dl)
+	d.run(sub)
+}
+
+// stop ends the workers.  every subscription must be finished.
+func (d *dispatcher) stop() {
+	close(d.done)
+	d.wg.Wait()
+}
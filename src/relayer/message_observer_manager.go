@@ -2,7 +2,9 @@ package relayer
 
 import (
 	"context"
+	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/google/uuid"
 	"github.com/mstreet3/message-relayer/domain"
@@ -18,121 +20,355 @@ type Event[T ~int] interface {
 }
 
 type ObserverManager[Type ~int, E Event[Type]] interface {
-	Subscribe(ctx context.Context, t Type) (<-chan E, func())
+	Subscribe(ctx context.Context, t Type, opts ...SubscribeOption) (<-chan E, func())
+	SubscribeWhere(ctx context.Context, pred func(E) bool, opts ...SubscribeOption) (<-chan E, func())
+	SubscribeAll(ctx context.Context, opts ...SubscribeOption) (<-chan E, func())
 	Notify(ctx context.Context, evt E)
 	Close()
 }
 
-type MessageObserverManager ObserverManager[domain.MessageType, domain.Message]
+type MessageObserverManager interface {
+	ObserverManager[domain.MessageType, domain.Message]
+	Stats() []SubscriptionStats
+}
 
 var _ MessageObserverManager = (*msgObserverManager)(nil)
 
 type msgObserverManager struct {
-	subscribers map[domain.MessageType]map[string]MessageObserver
-	stopCh      chan struct{}
+	subscribers map[domain.MessageType]map[string]*subscription
+	filtered    map[string]*subscription
+	wildcard    map[string]*subscription
+	dispatch    *dispatcher
+	workers     int
+	replay      map[domain.MessageType]*replayBuffer
+	seq         atomic.Uint64
+	stopped     bool
+	closeOnce   sync.Once
 	mu          sync.RWMutex
 	wg          sync.WaitGroup
 }
 
-func NewMessageObserverManager() *msgObserverManager {
-	return &msgObserverManager{
+// ManagerOption configures a message observer manager.
+type ManagerOption func(*msgObserverManager)
+
+// WithDispatchWorkers delivers the messages of every subscription with a
+// pool of n workers instead of a worker per subscription.  a subscriber
+// that blocks is waited on by a goroutine of its own rather than a pool
+// worker.  n below one keeps the worker per subscription, which is the
+// default.
+func WithDispatchWorkers(n int) ManagerOption {
+	return func(mom *msgObserverManager) {
+		if n < 0 {
+			n = 0
+		}
+		mom.workers = n
+	}
+}
+
+// WithReplayBuffer keeps the n most recent messages of type mt for
+// subscriptions that ask for a replay with WithReplay.
+func WithReplayBuffer(mt domain.MessageType, n int) ManagerOption {
+	return func(mom *msgObserverManager) {
+		if n < 1 {
+			delete(mom.replay, mt)
+			return
+		}
+		mom.replay[mt] = newReplayBuffer(n)
+	}
+}
+
+func NewMessageObserverManager(opts ...ManagerOption) *msgObserverManager {
+	mom := &msgObserverManager{
 		mu:          sync.RWMutex{},
 		wg:          sync.WaitGroup{},
-		stopCh:      make(chan struct{}),
-		subscribers: make(map[domain.MessageType]map[string]MessageObserver),
+		subscribers: make(map[domain.MessageType]map[string]*subscription),
+		filtered:    make(map[string]*subscription),
+		wildcard:    make(map[string]*subscription),
+		replay:      make(map[domain.MessageType]*replayBuffer),
 	}
+
+	for _, opt := range opts {
+		opt(mom)
+	}
+
+	if mom.workers > 0 {
+		mom.dispatch = newDispatcher(mom.workers)
+	}
+
+	return mom
+}
+
+// Subscribe delivers messages of type mt on the returned channel until ctx
+// is done, the manager is closed or the returned cleanup func is called.
+// by default a message is dropped if the subscriber is busy; opts select
+// another delivery mode.
+func (mom *msgObserverManager) Subscribe(ctx context.Context, mt domain.MessageType, opts ...SubscribeOption) (<-chan domain.Message, func()) {
+	return mom.subscribe(ctx, newSubscription(uuid.New(), Typed, mt, nil, newSubscribeConfig(opts)))
 }
 
-func (mom *msgObserverManager) Subscribe(ctx context.Context, mt domain.MessageType) (<-chan domain.Message, func()) {
+// SubscribeWhere is like Subscribe but delivers every message that matches
+// pred.  the manager evaluates pred once per message before fanning out.
+func (mom *msgObserverManager) SubscribeWhere(ctx context.Context, pred MessagePredicate, opts ...SubscribeOption) (<-chan domain.Message, func()) {
+	return mom.subscribe(ctx, newSubscription(uuid.New(), Filtered, 0, pred, newSubscribeConfig(opts)))
+}
+
+// SubscribeAll is like Subscribe but delivers messages of every type.
+// wildcard subscribers are notified of a message after its typed and
+// filtered subscribers.
+func (mom *msgObserverManager) SubscribeAll(ctx context.Context, opts ...SubscribeOption) (<-chan domain.Message, func()) {
+	return mom.subscribe(ctx, newSubscription(uuid.New(), Wildcard, 0, nil, newSubscribeConfig(opts)))
+}
+
+func (mom *msgObserverManager) subscribe(ctx context.Context, sub *subscription) (<-chan domain.Message, func()) {
 	var (
-		cleanupCh = make(chan struct{})
-		unsubbed  = make(chan struct{})
-		msgCh     = make(chan domain.Message)
-		id        = uuid.New()
-		stop      = utils.CtxOrDone(ctx, mom.stopCh)
-		handler   = func(msg domain.Message) error {
-			select {
-			case <-stop:
-				utils.DPrintf("%s: received stop signal", id)
-			case msgCh <- msg:
-				utils.DPrintf("%s: received message of type %s", id, msg.Type())
-			default:
-				utils.DPrintf("%s: dropped message of type %s", id, msg.Type())
-			}
-			return nil
-		}
-		mo = NewMessageObserver(id, handler)
+		cfg = sub.cfg
+		id  = sub.id
 	)
 
-	// add message observer to subscriber map
-	mom.add(mt, id, mo)
+	// add message observer to subscriber map, unless the manager stopped
+	mom.mu.Lock()
+	if mom.stopped {
+		mom.mu.Unlock()
+		utils.DPrintf("%s: received stop signal, closing chan", id)
+		sub.close()
+		sub.finish()
+		return sub.msgCh, func() {}
+	}
 
-	// listen for signal to close sub channel
+	done := ctx.Done()
+	sub.onClose = func() {
+		if mom.dispatch != nil {
+			mom.dispatch.end(sub)
+		}
+	}
+	sub.onFinish = mom.wg.Done
 	mom.wg.Add(1)
-	go func() {
-		defer mom.wg.Done()
-		defer close(unsubbed)
-		defer close(msgCh)
-		select {
-		case <-stop:
-			utils.DPrintf("%s: received stop signal, closing chan", id)
-		case <-cleanupCh:
-			utils.DPrintf("%s: received cleanup signal, closing chan", id)
+	if done != nil {
+		mom.wg.Add(1)
+	}
+	mom.add(sub)
+
+	// Notify holds the read lock, so every message is either replayed or
+	// delivered live
+	if cfg.replay > 0 {
+		for _, msg := range mom.replayed(sub, cfg.replay) {
+			sub.replay(msg)
 		}
-	}()
+	}
+	mom.mu.Unlock()
 
-	return msgCh, func() {
-		mom.remove(mt, id)
-		close(cleanupCh)
-		<-unsubbed
+	if mom.dispatch != nil && sub.queue.len() > 0 {
+		mom.dispatch.schedule(sub)
+	}
+
+	// a context that can be cancelled is watched until the subscription
+	// ends
+	if done != nil {
+		go func() {
+			defer mom.wg.Done()
+			select {
+			case <-done:
+				utils.DPrintf("%s: received stop signal, closing chan", id)
+				mom.end(sub)
+			case <-sub.closing:
+			}
+		}()
+	}
+
+	// without a dispatcher the subscription has a dedicated worker.  the
+	// worker is the only sender on msgCh, so messages are delivered in the
+	// order they were notified and msgCh is closed once it returns
+	if mom.dispatch == nil {
+		go func() {
+			defer sub.finish()
+			for {
+				d, ok := sub.queue.pop(sub.closing)
+				if !ok {
+					return
+				}
+				sub.observe(d)
+			}
+		}()
+	}
+
+	return sub.msgCh, func() {
+		utils.DPrintf("%s: received cleanup signal, closing chan", id)
+		mom.end(sub)
+		<-sub.done
 	}
 }
 
+// Notify fans msg out to the subscribers of its type, then to every
+// filtered subscriber whose predicate matches it and last to every
+// wildcard subscriber.  msg is queued for each subscription's worker, so
+// every subscriber receives messages in the order they were notified.
+// Notify only waits on a blocking subscriber whose queue is full, up to
+// the subscriber's timeout or until ctx is done.
 func (mom *msgObserverManager) Notify(ctx context.Context, msg domain.Message) {
 	mom.mu.RLock()
-	defer mom.mu.RUnlock()
+	if mom.stopped || ctx.Err() != nil {
+		mom.mu.RUnlock()
+		return
+	}
 
-	stop := utils.CtxOrDone(ctx, mom.stopCh)
-	msgType := msg.Type()
-	for _, sub := range mom.subscribers[msgType] {
-		select {
-		case <-stop:
-			return
-		default:
-			mom.wg.Add(1)
-			go func(sub MessageObserver) {
-				defer mom.wg.Done()
-				select {
-				case <-stop:
-					return
-				default:
-					_ = sub.Observe(msg)
-				}
-			}(sub)
+	if buf, ok := mom.replay[msg.Type()]; ok {
+		buf.record(mom.seq.Add(1), msg)
+	}
+
+	subs := make([]*subscription, 0, len(mom.subscribers[msg.Type()])+len(mom.filtered)+len(mom.wildcard))
+	for _, sub := range mom.subscribers[msg.Type()] {
+		subs = append(subs, sub)
+	}
+
+	for _, sub := range mom.filtered {
+		if sub.filter(msg) {
+			subs = append(subs, sub)
+		}
+	}
+
+	for _, sub := range mom.wildcard {
+		subs = append(subs, sub)
+	}
+	mom.mu.RUnlock()
+
+	// the lock is not held while a blocking subscriber is waited on, so
+	// subscriptions can still end
+	for _, sub := range subs {
+		if sub.enqueue(ctx, msg) && mom.dispatch != nil {
+			mom.dispatch.schedule(sub)
 		}
 	}
 }
 
+// Stats returns the delivery counters of every active subscription,
+// ordered by scope, typed subscriptions first, and then by message type.
+func (mom *msgObserverManager) Stats() []SubscriptionStats {
+	mom.mu.RLock()
+	subs := mom.all()
+	mom.mu.RUnlock()
+
+	stats := make([]SubscriptionStats, 0, len(subs))
+	for _, sub := range subs {
+		stats = append(stats, sub.stats())
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Scope != stats[j].Scope {
+			return stats[i].Scope < stats[j].Scope
+		}
+		if stats[i].Type != stats[j].Type {
+			return stats[i].Type < stats[j].Type
+		}
+		return stats[i].ID.String() < stats[j].ID.String()
+	})
+
+	return stats
+}
+
+// Close ends every subscription and waits for their channels to close.
+// subscriptions made after Close are closed right away.  Close is safe to
+// call more than once.
 func (mom *msgObserverManager) Close() {
-	defer utils.DPrintf("observer manager is shutdown")
-	defer mom.wg.Wait()
-	close(mom.stopCh)
+	mom.closeOnce.Do(func() {
+		defer utils.DPrintf("observer manager is shutdown")
+
+		mom.mu.Lock()
+		mom.stopped = true
+		subs := mom.all()
+		for _, sub := range subs {
+			mom.remove(sub)
+		}
+		mom.mu.Unlock()
+
+		for _, sub := range subs {
+			sub.close()
+		}
+		mom.wg.Wait()
+
+		if mom.dispatch != nil {
+			mom.dispatch.stop()
+		}
+	})
 }
 
-func (mom *msgObserverManager) add(mt domain.MessageType, id uuid.UUID, mo MessageObserver) {
+// replayed returns the last n buffered messages that sub observes.  the
+// caller must hold mu.
+func (mom *msgObserverManager) replayed(sub *subscription, n int) []domain.Message {
+	buffers := make([]*replayBuffer, 0, len(mom.replay))
+	if sub.scope == Typed {
+		if buf, ok := mom.replay[sub.mt]; ok {
+			buffers = append(buffers, buf)
+		}
+	} else {
+		for _, buf := range mom.replay {
+			buffers = append(buffers, buf)
+		}
+	}
+
+	return lastN(buffers, sub.matches, n)
+}
+
+// end removes sub from the subscriber maps and closes it.  once end holds
+// the lock no Notify can queue another message for sub.  end is safe to
+// call more than once.
+func (mom *msgObserverManager) end(sub *subscription) {
 	mom.mu.Lock()
-	defer mom.mu.Unlock()
+	mom.remove(sub)
+	mom.mu.Unlock()
+
+	sub.close()
+}
+
+// add puts sub in the subscriber maps.  the caller must hold mu.
+func (mom *msgObserverManager) add(sub *subscription) {
+	switch sub.scope {
+	case Filtered:
+		mom.filtered[sub.id.String()] = sub
+		return
+	case Wildcard:
+		mom.wildcard[sub.id.String()] = sub
+		return
+	}
 
-	if _, ok := mom.subscribers[mt]; !ok {
-		mom.subscribers[mt] = make(map[string]MessageObserver)
+	if _, ok := mom.subscribers[sub.mt]; !ok {
+		mom.subscribers[sub.mt] = make(map[string]*subscription)
 	}
 
-	mom.subscribers[mt][id.String()] = mo
+	mom.subscribers[sub.mt][sub.id.String()] = sub
 }
 
-func (mom *msgObserverManager) remove(mt domain.MessageType, uuid uuid.UUID) {
-	mom.mu.Lock()
-	defer mom.mu.Unlock()
+// remove takes sub out of the subscriber maps.  the caller must hold mu.
+func (mom *msgObserverManager) remove(sub *subscription) {
+	switch sub.scope {
+	case Filtered:
+		delete(mom.filtered, sub.id.String())
+		return
+	case Wildcard:
+		delete(mom.wildcard, sub.id.String())
+		return
+	}
+
+	delete(mom.subscribers[sub.mt], sub.id.String())
+	if len(mom.subscribers[sub.mt]) == 0 {
+		delete(mom.subscribers, sub.mt)
+	}
+}
+
+// all returns every subscription in the subscriber maps.  the caller must
+// hold mu.
+func (mom *msgObserverManager) all() []*subscription {
+	subs := make([]*subscription, 0, len(mom.filtered)+len(mom.wildcard))
+	for _, typed := range mom.subscribers {
+		for _, sub := range typed {
+			subs = append(subs, sub)
+		}
+	}
+	for _, sub := range mom.filtered {
+		subs = append(subs, sub)
+	}
+	for _, sub := range mom.wildcard {
+		subs = append(subs, sub)
+	}
 
-	delete(mom.subscribers[mt], uuid.String())
+	return subs
 }
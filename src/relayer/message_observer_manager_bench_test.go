@@ -0,0 +1,112 @@
+package relayer
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/mstreet3/message-relayer/domain"
+)
+
+// benchmarkNotify notifies b.N messages to subscribers that keep up with
+// the feed and reports the goroutines the manager runs to serve them.
+func benchmarkNotify(b *testing.B, subscribers int, opts ...ManagerOption) {
+	var (
+		baseline = runtime.NumGoroutine()
+		mom      = NewMessageObserverManager(opts...)
+		drained  = make(chan struct{}, subscribers)
+		msg      = domain.NewMessage(domain.StartNewRound, nil)
+	)
+
+	for i := 0; i < subscribers; i++ {
+		msgCh, _ := mom.Subscribe(context.Background(), domain.StartNewRound, WithBlockingDelivery(0))
+		go func() {
+			defer func() { drained <- struct{}{} }()
+			for count := 0; count < b.N; count++ {
+				<-msgCh
+			}
+		}()
+	}
+
+	// the receiving goroutines are not the manager's
+	goroutines := runtime.NumGoroutine() - baseline - subscribers
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		mom.Notify(context.Background(), msg)
+	}
+	for i := 0; i < subscribers; i++ {
+		<-drained
+	}
+
+	b.StopTimer()
+	mom.Close()
+
+	b.ReportMetric(float64(goroutines), "goroutines")
+}
+
+// BenchmarkNotify_goroutine_per_notify is the baseline: a goroutine per
+// subscriber for every message, the fan-out before delivery workers.  it
+// reports the goroutines left running once every message is notified.
+func BenchmarkNotify_goroutine_per_notify(b *testing.B) {
+	const subscribers = 1000
+
+	var (
+		baseline = runtime.NumGoroutine()
+		chans    = make([]chan domain.Message, subscribers)
+		drained  = make(chan struct{}, subscribers)
+		msg      = domain.NewMessage(domain.StartNewRound, nil)
+		wg       sync.WaitGroup
+	)
+
+	for i := range chans {
+		chans[i] = make(chan domain.Message)
+		go func(msgCh <-chan domain.Message) {
+			defer func() { drained <- struct{}{} }()
+			for count := 0; count < b.N; count++ {
+				<-msgCh
+			}
+		}(chans[i])
+	}
+
+	notify := func(msg domain.Message) {
+		for _, msgCh := range chans {
+			wg.Add(1)
+			go func(msgCh chan<- domain.Message) {
+				defer wg.Done()
+				msgCh <- msg
+			}(msgCh)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		notify(msg)
+	}
+	goroutines := runtime.NumGoroutine() - baseline - subscribers
+	for i := 0; i < subscribers; i++ {
+		<-drained
+	}
+
+	b.StopTimer()
+	wg.Wait()
+
+	b.ReportMetric(float64(goroutines), "goroutines")
+}
+
+func BenchmarkNotify_dedicated_workers(b *testing.B) {
+	benchmarkNotify(b, 1000)
+}
+
+func BenchmarkNotify_dispatch_workers_4(b *testing.B) {
+	benchmarkNotify(b, 1000, WithDispatchWorkers(4))
+}
+
+func BenchmarkNotify_dispatch_workers_GOMAXPROCS(b *testing.B) {
+	benchmarkNotify(b, 1000, WithDispatchWorkers(runtime.GOMAXPROCS(0)))
+}
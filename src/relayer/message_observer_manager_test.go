@@ -0,0 +1,834 @@
+package relayer
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mstreet3/message-relayer/domain"
+	"github.com/stretchr/testify/require"
+)
+
+// notifyN notifies n messages of type mt whose payload is their index.
+func notifyN(mom *msgObserverManager, mt domain.MessageType, n int) {
+	for i := 0; i < n; i++ {
+		mom.Notify(context.Background(), domain.NewMessage(mt, []byte{byte(i)}))
+	}
+}
+
+// receiveFor collects messages from ch until it is quiet for d.
+func receiveFor(ch <-chan domain.Message, d time.Duration) []domain.Message {
+	msgs := make([]domain.Message, 0)
+	for {
+		select {
+		case msg, open := <-ch:
+			if !open {
+				return msgs
+			}
+			msgs = append(msgs, msg)
+		case <-time.After(d):
+			return msgs
+		}
+	}
+}
+
+// receiveN collects n messages from ch or whatever arrives within d.
+func receiveN(ch <-chan domain.Message, n int, d time.Duration) []domain.Message {
+	var (
+		msgs    = make([]domain.Message, 0, n)
+		timeout = time.After(d)
+	)
+	for len(msgs) < n {
+		select {
+		case msg, open := <-ch:
+			if !open {
+				return msgs
+			}
+			msgs = append(msgs, msg)
+		case <-timeout:
+			return msgs
+		}
+	}
+	return msgs
+}
+
+func Test_drop_delivery_skips_busy_subscriber(t *testing.T) {
+	mom := NewMessageObserverManager()
+	defer mom.Close()
+
+	msgCh, cleanup := mom.Subscribe(context.Background(), domain.StartNewRound)
+	defer cleanup()
+
+	notifyN(mom, domain.StartNewRound, 5)
+	time.Sleep(50 * time.Millisecond)
+
+	require.Empty(t, receiveFor(msgCh, 20*time.Millisecond))
+}
+
+func Test_buffered_delivery_keeps_up_to_depth(t *testing.T) {
+	mom := NewMessageObserverManager()
+	defer mom.Close()
+
+	msgCh, cleanup := mom.Subscribe(context.Background(), domain.StartNewRound, WithBufferedDelivery(3))
+	defer cleanup()
+
+	notifyN(mom, domain.StartNewRound, 5)
+	require.Eventually(t, func() bool {
+		return len(msgCh) == 3
+	}, time.Second, time.Millisecond)
+
+	// a full buffer drops the remaining messages
+	time.Sleep(50 * time.Millisecond)
+	require.Len(t, receiveFor(msgCh, 20*time.Millisecond), 3)
+}
+
+func Test_blocking_delivery_waits_for_busy_subscriber(t *testing.T) {
+	mom := NewMessageObserverManager()
+	defer mom.Close()
+
+	msgCh, cleanup := mom.Subscribe(context.Background(), domain.StartNewRound, WithBlockingDelivery(time.Second))
+	defer cleanup()
+
+	// Notify waits while the subscription's queue is full
+	go notifyN(mom, domain.StartNewRound, 5)
+
+	// the subscriber is busy for a while before it starts receiving
+	time.Sleep(100 * time.Millisecond)
+
+	require.Len(t, receiveN(msgCh, 5, time.Second), 5)
+}
+
+func Test_blocking_delivery_drops_after_timeout(t *testing.T) {
+	mom := NewMessageObserverManager()
+	defer mom.Close()
+
+	msgCh, cleanup := mom.Subscribe(context.Background(), domain.StartNewRound, WithBlockingDelivery(10*time.Millisecond))
+	defer cleanup()
+
+	notifyN(mom, domain.StartNewRound, 5)
+	time.Sleep(100 * time.Millisecond)
+
+	require.Empty(t, receiveFor(msgCh, 20*time.Millisecond))
+}
+
+func Test_blocking_delivery_to_a_stalled_subscriber_is_bounded(t *testing.T) {
+	mom := NewMessageObserverManager()
+	defer mom.Close()
+
+	_, cleanup := mom.Subscribe(context.Background(), domain.StartNewRound, WithBlockingDelivery(time.Millisecond))
+	defer cleanup()
+
+	stats := mom.Stats()
+	require.Len(t, stats, 1)
+
+	mom.mu.RLock()
+	sub := mom.subscribers[domain.StartNewRound][stats[0].ID.String()]
+	mom.mu.RUnlock()
+
+	// nobody receives, so each message times out instead of piling up
+	for i := 0; i < 50; i++ {
+		notifyN(mom, domain.StartNewRound, 1)
+		require.LessOrEqual(t, sub.queue.len(), 1)
+	}
+
+	require.Eventually(t, func() bool {
+		return sub.stats().Dropped == 50
+	}, time.Second, time.Millisecond)
+}
+
+func Test_blocking_delivery_without_timeout_is_released_by_cleanup(t *testing.T) {
+	mom := NewMessageObserverManager()
+
+	msgCh, cleanup := mom.Subscribe(context.Background(), domain.StartNewRound, WithBlockingDelivery(0))
+
+	notified := make(chan struct{})
+	go func() {
+		defer close(notified)
+		notifyN(mom, domain.StartNewRound, 5)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	unsubscribed := make(chan struct{})
+	go func() {
+		defer close(unsubscribed)
+		cleanup()
+	}()
+
+	select {
+	case <-unsubscribed:
+	case <-time.After(time.Second):
+		t.Fatal("cleanup blocked on pending deliveries")
+	}
+
+	// the cleanup releases a Notify waiting on the subscription as well
+	select {
+	case <-notified:
+	case <-time.After(time.Second):
+		t.Fatal("Notify blocked after cleanup")
+	}
+
+	_, open := <-msgCh
+	require.False(t, open)
+
+	mom.Close()
+}
+
+func Test_Stats_count_deliveries_per_subscription(t *testing.T) {
+	var (
+		mom     = NewMessageObserverManager()
+		dropsCh = make(chan domain.Message, 10)
+	)
+	defer mom.Close()
+
+	bufCh, cleanupBuf := mom.Subscribe(context.Background(), domain.StartNewRound,
+		WithBufferedDelivery(2),
+		WithOnDrop(func(msg domain.Message) { dropsCh <- msg }),
+	)
+	defer cleanupBuf()
+
+	_, cleanupRA := mom.Subscribe(context.Background(), domain.ReceivedAnswer)
+	defer cleanupRA()
+
+	notifyN(mom, domain.StartNewRound, 5)
+
+	require.Eventually(t, func() bool {
+		stats := mom.Stats()
+		return len(stats) == 2 && stats[0].Delivered+stats[0].Dropped == 5
+	}, time.Second, time.Millisecond)
+
+	stats := mom.Stats()
+	require.Equal(t, domain.StartNewRound, stats[0].Type)
+	require.Equal(t, Buffered, stats[0].Mode)
+	require.Equal(t, uint64(2), stats[0].Delivered)
+	require.Equal(t, uint64(3), stats[0].Dropped)
+	require.Equal(t, uint64(0), stats[0].Stopped)
+
+	require.Equal(t, domain.ReceivedAnswer, stats[1].Type)
+	require.Equal(t, DropWhenBusy, stats[1].Mode)
+	require.Equal(t, SubscriptionStats{ID: stats[1].ID, Type: domain.ReceivedAnswer}, stats[1])
+
+	require.Len(t, dropsCh, 3)
+	for i := 0; i < 3; i++ {
+		require.Equal(t, domain.StartNewRound, (<-dropsCh).Type())
+	}
+	require.Len(t, receiveFor(bufCh, 20*time.Millisecond), 2)
+}
+
+func Test_Stats_count_stopped_deliveries(t *testing.T) {
+	mom := NewMessageObserverManager()
+	defer mom.Close()
+
+	_, cleanup := mom.Subscribe(context.Background(), domain.StartNewRound, WithBlockingDelivery(0))
+
+	stats := mom.Stats()
+	require.Len(t, stats, 1)
+
+	mom.mu.RLock()
+	sub := mom.subscribers[domain.StartNewRound][stats[0].ID.String()]
+	mom.mu.RUnlock()
+
+	// nobody receives, so every delivery blocks until the cleanup
+	go notifyN(mom, domain.StartNewRound, 3)
+	time.Sleep(20 * time.Millisecond)
+	cleanup()
+
+	require.Eventually(t, func() bool {
+		return sub.stats().Stopped == 3
+	}, time.Second, time.Millisecond)
+	require.Empty(t, mom.Stats())
+}
+
+func Test_SubscribeWhere_receives_matching_messages(t *testing.T) {
+	mom := NewMessageObserverManager()
+	defer mom.Close()
+
+	msgCh, cleanup := mom.SubscribeWhere(context.Background(),
+		And(OfTypes(domain.StartNewRound, domain.ReceivedAnswer), func(msg domain.Message) bool {
+			return msg.Data[0]%2 == 0
+		}),
+		WithBufferedDelivery(10),
+	)
+	defer cleanup()
+
+	notifyN(mom, domain.StartNewRound, 4)
+	notifyN(mom, domain.ReceivedAnswer, 3)
+	notifyN(mom, heartbeat, 4)
+
+	msgs := receiveFor(msgCh, 50*time.Millisecond)
+	require.Len(t, msgs, 4)
+
+	counts := map[domain.MessageType]int{}
+	for _, msg := range msgs {
+		require.Zero(t, msg.Data[0]%2)
+		counts[msg.Type()]++
+	}
+	require.Equal(t, map[domain.MessageType]int{domain.StartNewRound: 2, domain.ReceivedAnswer: 2}, counts)
+}
+
+func Test_SubscribeWhere_filters_before_fan_out(t *testing.T) {
+	mom := NewMessageObserverManager()
+	defer mom.Close()
+
+	_, cleanup := mom.SubscribeWhere(context.Background(), OfTypes(domain.ReceivedAnswer))
+	defer cleanup()
+
+	notifyN(mom, domain.StartNewRound, 5)
+	time.Sleep(20 * time.Millisecond)
+
+	// messages the predicate rejects are never offered to the subscriber
+	stats := mom.Stats()
+	require.Len(t, stats, 1)
+	require.Equal(t, Filtered, stats[0].Scope)
+	require.Zero(t, stats[0].Delivered+stats[0].Dropped+stats[0].Stopped)
+}
+
+func Test_SubscribeWhere_cleanup_removes_subscription(t *testing.T) {
+	mom := NewMessageObserverManager()
+	defer mom.Close()
+
+	msgCh, cleanup := mom.SubscribeWhere(context.Background(), Not(OfTypes()))
+	require.Len(t, mom.Stats(), 1)
+
+	cleanup()
+
+	_, open := <-msgCh
+	require.False(t, open)
+	require.Empty(t, mom.Stats())
+}
+
+func Test_predicate_composition(t *testing.T) {
+	var (
+		snr  = domain.NewMessage(domain.StartNewRound, nil)
+		ra   = domain.NewMessage(domain.ReceivedAnswer, nil)
+		none = OfTypes()
+		all  = Not(none)
+	)
+
+	require.True(t, OfTypes(domain.StartNewRound)(snr))
+	require.False(t, OfTypes(domain.StartNewRound)(ra))
+	require.False(t, none(snr))
+	require.True(t, all(ra))
+
+	require.True(t, And()(snr))
+	require.False(t, And(all, none)(snr))
+	require.False(t, Or()(snr))
+	require.True(t, Or(none, all)(snr))
+}
+
+func Test_SubscribeAll_receives_every_type(t *testing.T) {
+	mom := NewMessageObserverManager()
+	defer mom.Close()
+
+	allCh, cleanupAll := mom.SubscribeAll(context.Background(), WithBufferedDelivery(10))
+	defer cleanupAll()
+
+	snrCh, cleanupSNR := mom.Subscribe(context.Background(), domain.StartNewRound, WithBufferedDelivery(10))
+	defer cleanupSNR()
+
+	notifyN(mom, domain.StartNewRound, 2)
+	notifyN(mom, domain.ReceivedAnswer, 2)
+	notifyN(mom, heartbeat, 2)
+
+	counts := map[domain.MessageType]int{}
+	for _, msg := range receiveFor(allCh, 50*time.Millisecond) {
+		counts[msg.Type()]++
+	}
+	require.Equal(t, map[domain.MessageType]int{
+		domain.StartNewRound:  2,
+		domain.ReceivedAnswer: 2,
+		heartbeat:             2,
+	}, counts)
+
+	// typed subscribers are unaffected by the wildcard
+	require.Len(t, receiveFor(snrCh, 20*time.Millisecond), 2)
+
+	stats := mom.Stats()
+	require.Len(t, stats, 2)
+	require.Equal(t, Typed, stats[0].Scope)
+	require.Equal(t, Wildcard, stats[1].Scope)
+	require.Equal(t, uint64(6), stats[1].Delivered)
+}
+
+func Test_SubscribeAll_ends_like_typed_subscriptions(t *testing.T) {
+	mom := NewMessageObserverManager()
+
+	cleanedCh, cleanup := mom.SubscribeAll(context.Background())
+	closedCh, _ := mom.SubscribeAll(context.Background())
+	require.Len(t, mom.Stats(), 2)
+
+	cleanup()
+	_, open := <-cleanedCh
+	require.False(t, open)
+	require.Len(t, mom.Stats(), 1)
+
+	mom.Close()
+	_, open = <-closedCh
+	require.False(t, open)
+}
+
+// notifySeq notifies n messages alternating between types whose payload is
+// their big endian index.
+func notifySeq(mom *msgObserverManager, n int, types ...domain.MessageType) {
+	for i := 0; i < n; i++ {
+		data := make([]byte, 4)
+		binary.BigEndian.PutUint32(data, uint32(i))
+		mom.Notify(context.Background(), domain.NewMessage(types[i%len(types)], data))
+	}
+}
+
+// requireOrdered checks that msgs were received in increasing index order.
+func requireOrdered(t *testing.T, msgs []domain.Message) {
+	t.Helper()
+	for i := 1; i < len(msgs); i++ {
+		prev, next := binary.BigEndian.Uint32(msgs[i-1].Data), binary.BigEndian.Uint32(msgs[i].Data)
+		require.Less(t, prev, next, "message %d received after %d", next, prev)
+	}
+}
+
+func Test_delivery_preserves_notify_order(t *testing.T) {
+	const n = 500
+
+	tests := []struct {
+		name string
+		opt  SubscribeOption
+		all  bool
+	}{
+		{name: "blocking", opt: WithBlockingDelivery(0), all: true},
+		{name: "buffered", opt: WithBufferedDelivery(n), all: true},
+		{name: "drop", opt: WithDropDelivery()},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mom := NewMessageObserverManager()
+			defer mom.Close()
+
+			msgCh, cleanup := mom.Subscribe(context.Background(), domain.StartNewRound, tt.opt)
+			defer cleanup()
+
+			go notifySeq(mom, n, domain.StartNewRound)
+
+			var msgs []domain.Message
+			if tt.all {
+				msgs = receiveN(msgCh, n, 5*time.Second)
+				require.Len(t, msgs, n)
+			} else {
+				msgs = receiveFor(msgCh, 100*time.Millisecond)
+			}
+			requireOrdered(t, msgs)
+		})
+	}
+}
+
+func Test_delivery_order_across_types_and_subscribers(t *testing.T) {
+	const (
+		n           = 300
+		subscribers = 8
+	)
+
+	mom := NewMessageObserverManager()
+	defer mom.Close()
+
+	var (
+		chans = make([]<-chan domain.Message, 0, subscribers)
+		want  = []int{n, n, n / 2, n / 2}
+	)
+	for i := 0; i < subscribers/4; i++ {
+		for _, subscribe := range []func() (<-chan domain.Message, func()){
+			func() (<-chan domain.Message, func()) {
+				return mom.SubscribeAll(context.Background(), WithBlockingDelivery(0))
+			},
+			func() (<-chan domain.Message, func()) {
+				return mom.SubscribeWhere(context.Background(), OfTypes(domain.StartNewRound, domain.ReceivedAnswer), WithBlockingDelivery(0))
+			},
+			func() (<-chan domain.Message, func()) {
+				return mom.Subscribe(context.Background(), domain.StartNewRound, WithBlockingDelivery(0))
+			},
+			func() (<-chan domain.Message, func()) {
+				return mom.Subscribe(context.Background(), domain.ReceivedAnswer, WithBlockingDelivery(0))
+			},
+		} {
+			msgCh, cleanup := subscribe()
+			defer cleanup()
+			chans = append(chans, msgCh)
+		}
+	}
+
+	go notifySeq(mom, n, domain.StartNewRound, domain.ReceivedAnswer)
+
+	var (
+		wg       sync.WaitGroup
+		received = make([][]domain.Message, len(chans))
+	)
+	for i, msgCh := range chans {
+		wg.Add(1)
+		go func(i int, msgCh <-chan domain.Message) {
+			defer wg.Done()
+			received[i] = receiveN(msgCh, want[i%4], 5*time.Second)
+		}(i, msgCh)
+	}
+	wg.Wait()
+
+	for i, msgs := range received {
+		require.Len(t, msgs, want[i%4])
+		requireOrdered(t, msgs)
+	}
+}
+
+func Test_dispatch_workers_preserve_order(t *testing.T) {
+	const (
+		n           = 300
+		subscribers = 16
+	)
+
+	mom := NewMessageObserverManager(WithDispatchWorkers(3))
+
+	chans := make([]<-chan domain.Message, 0, subscribers)
+	for i := 0; i < subscribers; i++ {
+		msgCh, _ := mom.Subscribe(context.Background(), domain.StartNewRound, WithBufferedDelivery(n))
+		chans = append(chans, msgCh)
+	}
+
+	notifySeq(mom, n, domain.StartNewRound)
+
+	require.Eventually(t, func() bool {
+		for _, stats := range mom.Stats() {
+			if stats.Delivered != n {
+				return false
+			}
+		}
+		return true
+	}, time.Second, time.Millisecond)
+
+	// closing the manager finishes every subscription on the pool
+	mom.Close()
+	for _, msgCh := range chans {
+		msgs := receiveFor(msgCh, time.Second)
+		require.Len(t, msgs, n)
+		requireOrdered(t, msgs)
+	}
+}
+
+func Test_dispatch_workers_are_not_held_by_blocked_subscribers(t *testing.T) {
+	mom := NewMessageObserverManager(WithDispatchWorkers(1))
+	defer mom.Close()
+
+	// nobody receives, so every blocking subscriber waits for good
+	cleanups := make([]func(), 0, 3)
+	for i := 0; i < 3; i++ {
+		_, cleanup := mom.Subscribe(context.Background(), domain.StartNewRound, WithBlockingDelivery(0))
+		cleanups = append(cleanups, cleanup)
+	}
+	msgCh, cleanupRA := mom.Subscribe(context.Background(), domain.ReceivedAnswer, WithBufferedDelivery(1))
+	defer cleanupRA()
+
+	notifyN(mom, domain.StartNewRound, 1)
+	time.Sleep(20 * time.Millisecond)
+
+	// the only worker is free to serve another subscriber
+	notifyN(mom, domain.ReceivedAnswer, 1)
+	require.Len(t, receiveFor(msgCh, 50*time.Millisecond), 1)
+
+	for _, cleanup := range cleanups {
+		cleanup()
+	}
+	require.Eventually(t, func() bool {
+		return len(mom.Stats()) == 1
+	}, time.Second, time.Millisecond)
+}
+
+func Test_dispatch_workers_cleanup_without_a_free_worker(t *testing.T) {
+	mom := NewMessageObserverManager(WithDispatchWorkers(1))
+	defer mom.Close()
+
+	// nobody receives, so both subscribers are waited on
+	_, cleanupBlocked := mom.Subscribe(context.Background(), domain.StartNewRound, WithBlockingDelivery(0))
+	defer cleanupBlocked()
+	waitingCh, cleanupWaiting := mom.Subscribe(context.Background(), domain.StartNewRound, WithBlockingDelivery(0))
+
+	go notifyN(mom, domain.StartNewRound, 3)
+	time.Sleep(20 * time.Millisecond)
+
+	// the waiting subscription is finished by its cleanup
+	cleaned := make(chan struct{})
+	go func() {
+		defer close(cleaned)
+		cleanupWaiting()
+	}()
+
+	select {
+	case <-cleaned:
+	case <-time.After(time.Second):
+		t.Fatal("cleanup waited for a busy worker")
+	}
+
+	_, open := <-waitingCh
+	require.False(t, open)
+}
+
+func Test_dispatch_workers_finish_subscriptions_after_Close(t *testing.T) {
+	mom := NewMessageObserverManager(WithDispatchWorkers(2))
+	mom.Close()
+
+	msgCh, cleanup := mom.Subscribe(context.Background(), domain.StartNewRound)
+	defer cleanup()
+
+	_, open := <-msgCh
+	require.False(t, open)
+}
+
+func Test_cancelled_context_frees_subscription(t *testing.T) {
+	for _, workers := range []int{0, 2} {
+		mom := NewMessageObserverManager(WithDispatchWorkers(workers))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		msgCh, cleanup := mom.Subscribe(ctx, domain.StartNewRound)
+		allCh, _ := mom.SubscribeAll(ctx)
+		require.Len(t, mom.Stats(), 2)
+
+		cancel()
+
+		_, open := <-msgCh
+		require.False(t, open)
+		_, open = <-allCh
+		require.False(t, open)
+		require.Eventually(t, func() bool {
+			return len(mom.Stats()) == 0
+		}, time.Second, time.Millisecond)
+
+		// cleanup after the context is done is harmless
+		cleanup()
+		mom.Close()
+	}
+}
+
+// permutations returns every ordering of the indexes 0 to n-1.
+func permutations(n int) [][]int {
+	if n == 0 {
+		return [][]int{{}}
+	}
+
+	perms := make([][]int, 0)
+	for _, perm := range permutations(n - 1) {
+		for i := 0; i <= len(perm); i++ {
+			next := append(append(append([]int{}, perm[:i]...), n-1), perm[i:]...)
+			perms = append(perms, next)
+		}
+	}
+	return perms
+}
+
+func Test_subscription_teardown_in_every_order(t *testing.T) {
+	for _, workers := range []int{0, 2} {
+		for _, order := range permutations(4) {
+			var (
+				mom         = NewMessageObserverManager(WithDispatchWorkers(workers))
+				ctx, cancel = context.WithCancel(context.Background())
+				stopNotify  = make(chan struct{})
+				notifying   = make(chan struct{})
+			)
+
+			// a blocked delivery is in flight whenever the subscription ends
+			msgCh, cleanup := mom.Subscribe(ctx, domain.StartNewRound, WithBlockingDelivery(0))
+			allCh, cleanupAll := mom.SubscribeAll(ctx, WithBufferedDelivery(2))
+
+			go func() {
+				defer close(notifying)
+				for {
+					select {
+					case <-stopNotify:
+						return
+					default:
+						notifyN(mom, domain.StartNewRound, 1)
+					}
+				}
+			}()
+
+			terminations := []func(){cancel, cleanup, cleanup, mom.Close}
+			for i, step := range order {
+				terminations[step]()
+				if i == 0 {
+					cleanupAll()
+				}
+			}
+
+			close(stopNotify)
+			<-notifying
+
+			for range msgCh {
+			}
+			for range allCh {
+			}
+			require.Empty(t, mom.Stats(), "workers %d, order %v", workers, order)
+
+			mom.Close()
+		}
+	}
+}
+
+func Test_subscription_teardown_concurrently(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		var (
+			mom         = NewMessageObserverManager(WithDispatchWorkers(i % 3))
+			ctx, cancel = context.WithCancel(context.Background())
+			wg          sync.WaitGroup
+		)
+
+		msgCh, cleanup := mom.Subscribe(ctx, domain.StartNewRound, WithBlockingDelivery(0))
+
+		for _, step := range []func(){
+			cancel,
+			cleanup,
+			cleanup,
+			mom.Close,
+			func() { notifyN(mom, domain.StartNewRound, 10) },
+			func() {
+				_, cleanup := mom.SubscribeWhere(ctx, OfTypes(domain.StartNewRound))
+				cleanup()
+			},
+		} {
+			wg.Add(1)
+			go func(step func()) {
+				defer wg.Done()
+				step()
+			}(step)
+		}
+		wg.Wait()
+
+		for range msgCh {
+		}
+		require.Empty(t, mom.Stats())
+	}
+}
+
+// indexes returns the big endian index payloads of msgs.
+func indexes(msgs []domain.Message) []uint32 {
+	idx := make([]uint32, 0, len(msgs))
+	for _, msg := range msgs {
+		idx = append(idx, binary.BigEndian.Uint32(msg.Data))
+	}
+	return idx
+}
+
+func Test_replay_delivers_last_n_before_live_messages(t *testing.T) {
+	mom := NewMessageObserverManager(WithReplayBuffer(domain.StartNewRound, 5))
+	defer mom.Close()
+
+	notifySeq(mom, 8, domain.StartNewRound)
+
+	lastCh, cleanupLast := mom.Subscribe(context.Background(), domain.StartNewRound, WithReplay(3), WithBufferedDelivery(10))
+	defer cleanupLast()
+	allCh, cleanupAll := mom.Subscribe(context.Background(), domain.StartNewRound, WithReplay(10), WithBufferedDelivery(10))
+	defer cleanupAll()
+	noneCh, cleanupNone := mom.Subscribe(context.Background(), domain.StartNewRound, WithBufferedDelivery(10))
+	defer cleanupNone()
+
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, 8)
+	mom.Notify(context.Background(), domain.NewMessage(domain.StartNewRound, data))
+
+	require.Equal(t, []uint32{5, 6, 7, 8}, indexes(receiveN(lastCh, 4, time.Second)))
+	// the buffer only holds the last 5
+	require.Equal(t, []uint32{3, 4, 5, 6, 7, 8}, indexes(receiveN(allCh, 6, time.Second)))
+	require.Equal(t, []uint32{8}, indexes(receiveN(noneCh, 1, time.Second)))
+}
+
+func Test_replay_is_not_dropped_by_a_busy_subscriber(t *testing.T) {
+	for _, workers := range []int{0, 2} {
+		mom := NewMessageObserverManager(WithDispatchWorkers(workers), WithReplayBuffer(domain.StartNewRound, 5))
+
+		notifySeq(mom, 5, domain.StartNewRound)
+
+		dropCh, _ := mom.Subscribe(context.Background(), domain.StartNewRound, WithReplay(4))
+		shallowCh, _ := mom.Subscribe(context.Background(), domain.StartNewRound, WithBufferedDelivery(2), WithReplay(4))
+
+		// nobody receives while the replay is delivered
+		time.Sleep(20 * time.Millisecond)
+
+		require.Equal(t, []uint32{1, 2, 3, 4}, indexes(receiveN(dropCh, 4, time.Second)))
+		require.Equal(t, []uint32{1, 2, 3, 4}, indexes(receiveN(shallowCh, 4, time.Second)))
+
+		// live messages keep the mode the subscriptions asked for
+		notifyN(mom, domain.StartNewRound, 4)
+		time.Sleep(20 * time.Millisecond)
+		require.Empty(t, receiveFor(dropCh, 20*time.Millisecond))
+		require.Len(t, receiveFor(shallowCh, 20*time.Millisecond), 2)
+
+		modes := make(map[DeliveryMode]uint64)
+		for _, stats := range mom.Stats() {
+			modes[stats.Mode] = stats.Dropped
+		}
+		require.Equal(t, map[DeliveryMode]uint64{DropWhenBusy: 4, Buffered: 2}, modes, "workers %d", workers)
+
+		mom.Close()
+	}
+}
+
+func Test_replay_merges_types_in_notify_order(t *testing.T) {
+	mom := NewMessageObserverManager(
+		WithReplayBuffer(domain.StartNewRound, 10),
+		WithReplayBuffer(domain.ReceivedAnswer, 10),
+	)
+	defer mom.Close()
+
+	notifySeq(mom, 6, domain.StartNewRound, domain.ReceivedAnswer, heartbeat)
+
+	allCh, cleanupAll := mom.SubscribeAll(context.Background(), WithReplay(3), WithBufferedDelivery(10))
+	defer cleanupAll()
+	raCh, cleanupRA := mom.SubscribeWhere(context.Background(), OfTypes(domain.ReceivedAnswer), WithReplay(10), WithBufferedDelivery(10))
+	defer cleanupRA()
+
+	// heartbeat has no replay buffer
+	require.Equal(t, []uint32{1, 3, 4}, indexes(receiveN(allCh, 3, time.Second)))
+	require.Equal(t, []uint32{1, 4}, indexes(receiveN(raCh, 2, time.Second)))
+}
+
+func Test_replay_has_no_gaps_or_duplicates(t *testing.T) {
+	const (
+		n           = 400
+		subscribers = 8
+	)
+
+	for _, workers := range []int{0, 2} {
+		mom := NewMessageObserverManager(
+			WithDispatchWorkers(workers),
+			WithReplayBuffer(domain.StartNewRound, n),
+		)
+
+		notified := make(chan struct{})
+		go func() {
+			defer close(notified)
+			notifySeq(mom, n, domain.StartNewRound)
+		}()
+
+		// subscribe while messages are being notified
+		var (
+			wg       sync.WaitGroup
+			received = make([][]domain.Message, subscribers)
+		)
+		for i := 0; i < subscribers; i++ {
+			msgCh, _ := mom.Subscribe(context.Background(), domain.StartNewRound, WithReplay(n), WithBlockingDelivery(0))
+
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				received[i] = receiveN(msgCh, n, 5*time.Second)
+			}(i)
+			time.Sleep(time.Millisecond)
+		}
+		wg.Wait()
+		<-notified
+
+		for _, msgs := range received {
+			require.Len(t, msgs, n)
+			for i, idx := range indexes(msgs) {
+				require.Equal(t, uint32(i), idx)
+			}
+		}
+
+		mom.Close()
+	}
+}
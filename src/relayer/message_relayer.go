@@ -2,12 +2,16 @@ package relayer
 
 import (
 	"context"
-	"errors"
-	"log"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/mstreet3/message-relayer/codec"
 	"github.com/mstreet3/message-relayer/domain"
-	"github.com/mstreet3/message-relayer/errs"
 	"github.com/mstreet3/message-relayer/network"
 	"github.com/mstreet3/message-relayer/utils"
 )
@@ -15,63 +19,150 @@ import (
 type mailbox[T any] interface {
 	Add(T)
 	Empty(context.Context) <-chan T
+	Ready() <-chan struct{}
 }
 
 type messageRelayer struct {
-	om      MessageObserverManager
-	network network.RestartNetworkReader
-	mailbox mailbox[domain.Message]
-	pulse   time.Duration
+	om               MessageObserverManager
+	network          network.RestartNetworkReader
+	mailbox          mailbox[domain.Message]
+	pulse            time.Duration
+	heartbeatTimeout time.Duration
+	restartPolicy    RestartPolicy
+	handleErr        ErrorHandler
+	dedup            *dedupWindow
+	seq              *sequencer
+	lastRead         atomic.Int64
+	lifecycle        lifecycle
+	terminated       chan struct{}
+	mu               sync.Mutex
+	err              error
 }
 
 func NewMessageRelayer(
 	n network.RestartNetworkReader,
 	mailbox mailbox[domain.Message],
 	om MessageObserverManager,
-) *messageRelayer {
-	return &messageRelayer{
-		network: n,
-		mailbox: mailbox,
-		om:      om,
-		pulse:   80 * time.Millisecond,
+	opts ...Option,
+) (*messageRelayer, error) {
+	mr := &messageRelayer{
+		network:          n,
+		mailbox:          mailbox,
+		om:               om,
+		pulse:            DefaultReadInterval,
+		heartbeatTimeout: DefaultHeartbeatTimeout,
+		restartPolicy:    NewBackoffPolicy(BackoffConfig{}),
+		handleErr:        DefaultErrorHandler,
+		seq:              newSequencer(),
+		terminated:       make(chan struct{}),
 	}
+
+	for _, opt := range opts {
+		if err := opt(mr); err != nil {
+			return nil, err
+		}
+	}
+
+	// a mailbox that replays the messages of an earlier relayer tells it
+	// where their numbering stopped
+	if src, ok := mailbox.(sequenceSource); ok {
+		mr.seq.seed(src.Sequences())
+	}
+
+	return mr, nil
 }
 
+// Start relays messages until ctx is done or the relayer terminates on an
+// error.  the returned channel is closed once the relayer is stopped.  a
+// relayer is started once; later calls return the same channel.
 func (mr *messageRelayer) Start(ctx context.Context) <-chan struct{} {
+	if !mr.lifecycle.start() {
+		return mr.terminated
+	}
+	mr.lifecycle.set(Running, nil)
+
 	var (
-		ctxwc, cancel      = context.WithCancel(ctx)
-		terminated         = make(chan struct{})
-		reading, hb, errCh = mr.read(ctxwc)
-		monitoring         = mr.monitor(ctxwc, hb, errCh)
+		ctxwc, cancel  = context.WithCancel(ctx)
+		reading, errCh = mr.read(ctxwc)
+		monitoring     = mr.monitor(ctxwc, errCh)
 	)
 
 	go func() {
-		defer close(terminated)
+		defer close(mr.terminated)
+		defer func() {
+			mr.lifecycle.set(Stopped, mr.Err())
+		}()
 		defer mr.om.Close()
 		defer cancel()
-		<-reading
 		<-monitoring
+		mr.lifecycle.set(Stopping, nil)
+		cancel()
+		<-reading
 	}()
 
-	return terminated
+	return mr.terminated
+}
+
+// Duplicates returns the number of messages read from the network that
+// were dropped as duplicates.  it is zero without WithDeduplication.
+func (mr *messageRelayer) Duplicates() uint64 {
+	if mr.dedup == nil {
+		return 0
+	}
+	return mr.dedup.suppressed.Load()
+}
+
+// Wait blocks until the relayer is stopped and returns Err.
+func (mr *messageRelayer) Wait() error {
+	<-mr.terminated
+	return mr.Err()
+}
+
+// State returns the current lifecycle state.
+func (mr *messageRelayer) State() State {
+	return mr.lifecycle.get()
+}
+
+// States delivers every later lifecycle transition.  the channel is closed
+// after the transition to Stopped.
+func (mr *messageRelayer) States() <-chan StateChange {
+	return mr.lifecycle.watch()
 }
 
-func (mr *messageRelayer) Subscribe(mt domain.MessageType) (<-chan domain.Message, func()) {
-	return mr.om.Subscribe(context.Background(), mt)
+// Err returns the error that terminated the relayer: the error the error
+// handler stopped on, or the error of a restart policy that gave up.  it
+// is nil while the relayer runs and after a shutdown by its context.
+func (mr *messageRelayer) Err() error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	return mr.err
+}
+
+// Subscribe delivers messages of type mt until ctx is done, the relayer
+// stops or the returned cleanup func is called.
+func (mr *messageRelayer) Subscribe(ctx context.Context, mt domain.MessageType, opts ...SubscribeOption) (<-chan domain.Message, func()) {
+	return mr.om.Subscribe(ctx, mt, opts...)
+}
+
+// SubscribeWhere delivers every message that matches pred, see OfTypes to
+// subscribe to several types at once.
+func (mr *messageRelayer) SubscribeWhere(ctx context.Context, pred MessagePredicate, opts ...SubscribeOption) (<-chan domain.Message, func()) {
+	return mr.om.SubscribeWhere(ctx, pred, opts...)
 }
 
-func (mr *messageRelayer) read(ctx context.Context) (<-chan struct{}, <-chan struct{}, <-chan error) {
+// SubscribeAll delivers messages of every type.
+func (mr *messageRelayer) SubscribeAll(ctx context.Context, opts ...SubscribeOption) (<-chan domain.Message, func()) {
+	return mr.om.SubscribeAll(ctx, opts...)
+}
+
+// read pulls messages off the network into the mailbox.  a network that
+// implements network.StreamReader is read as fast as messages arrive;
+// otherwise it is polled once per pulse.
+func (mr *messageRelayer) read(ctx context.Context) (<-chan struct{}, <-chan error) {
 	var (
-		ticker    = time.NewTicker(mr.pulse)
-		done      = make(chan struct{})
-		errCh     = make(chan error, 1)
-		hb        = make(chan struct{}, 1)
-		sendPulse = func() {
-			select {
-			case hb <- struct{}{}:
-			default:
-			}
-		}
+		done    = make(chan struct{})
+		errCh   = make(chan error, 1)
 		sendErr = func(err error) {
 			select {
 			case errCh <- err:
@@ -79,13 +170,69 @@ func (mr *messageRelayer) read(ctx context.Context) (<-chan struct{}, <-chan str
 				utils.DPrintf("no error subscribers")
 			}
 		}
-		enqueue = func(msg *domain.Message) {
-			utils.DPrintf("placing message of type %s in mailbox\n", msg.Type())
+		enqueue = func(read *domain.Message) {
+			// a reader may hand out the same message more than once, so
+			// the copy is stamped rather than the message read
+			msg := *read
 			msg.Timestamp = time.Now().UTC().UnixNano()
-			mr.mailbox.Add(*msg)
+			mr.lastRead.Store(msg.Timestamp)
+			if mr.dedup != nil && mr.dedup.duplicate(msg) {
+				utils.DPrintf("dropping duplicate message of type %s\n", msg.Type())
+				return
+			}
+			// a message keeps the id it was read with, so it is stable
+			// across relayers.  an id too long for a frame is replaced by
+			// its hash, which is just as stable
+			switch {
+			case msg.ID == "":
+				msg.ID = uuid.NewString()
+			case len(msg.ID) > codec.MaxIDSize:
+				msg.ID = hashID(msg.ID)
+			}
+			msg.Sequence = mr.seq.next(msg.Type())
+			utils.DPrintf("placing message of type %s in mailbox\n", msg.Type())
+			mr.mailbox.Add(msg)
 		}
 	)
 
+	if s, ok := mr.network.(network.StreamReader); ok {
+		// a stream waits on a restart after a fatal error, so errors
+		// must reach the monitor rather than being dropped
+		sendErr = func(err error) {
+			select {
+			case <-ctx.Done():
+			case errCh <- err:
+			}
+		}
+
+		go func() {
+			defer close(done)
+			defer close(errCh)
+
+			responses := s.Stream(ctx)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case res, open := <-responses:
+					if !open {
+						return
+					}
+					if res.Error != nil {
+						sendErr(res.Error)
+						continue
+					}
+
+					enqueue(res.Message)
+				}
+			}
+		}()
+
+		return done, errCh
+	}
+
+	ticker := time.NewTicker(mr.pulse)
+
 	go func() {
 		defer close(done)
 		defer close(errCh)
@@ -103,35 +250,59 @@ func (mr *messageRelayer) read(ctx context.Context) (<-chan struct{}, <-chan str
 				}
 
 				enqueue(msg)
-
-				sendPulse()
 			}
 		}
 	}()
 
-	return done, hb, errCh
+	return done, errCh
+}
+
+// hashID returns the hex SHA-256 of id, which fits in a frame.
+func hashID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
 }
 
-func (mr *messageRelayer) monitor(ctx context.Context, hb <-chan struct{}, errCh <-chan error) <-chan struct{} {
+// monitor empties the mailbox as soon as a message is added to it and
+// handles the errors of the network.  it returns once the context is done
+// or an error is handled by stopping the relayer.
+func (mr *messageRelayer) monitor(ctx context.Context, errCh <-chan error) <-chan struct{} {
 	done := make(chan struct{})
 
+	mr.lastRead.Store(time.Now().UTC().UnixNano())
+
 	go func() {
 		defer close(done)
+
+		// the watchdog checks for a stalled network once per pulse
+		var watchdog <-chan time.Time
+		if mr.heartbeatTimeout > 0 {
+			ticker := time.NewTicker(mr.pulse)
+			defer ticker.Stop()
+			watchdog = ticker.C
+		}
+
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case <-hb:
+			case <-mr.mailbox.Ready():
 				<-mr.notify(ctx, mr.mailbox.Empty(ctx))
+			case <-watchdog:
+				since := time.Since(time.Unix(0, mr.lastRead.Load()))
+				if since < mr.heartbeatTimeout {
+					continue
+				}
+				mr.lastRead.Store(time.Now().UTC().UnixNano())
+				if !mr.handle(ctx, ErrHeartbeatTimeout) {
+					return
+				}
 			case err, open := <-errCh:
 				if !open {
 					return
 				}
-				if errors.Is(err, errs.FatalSocketError{}) {
-					utils.DPrintf("%s\n", err.Error())
-					if rerr := mr.network.Restart(); rerr != nil {
-						log.Fatal(rerr)
-					}
+				if !mr.handle(ctx, err) {
+					return
 				}
 			}
 		}
@@ -140,6 +311,39 @@ func (mr *messageRelayer) monitor(ctx context.Context, hb <-chan struct{}, errCh
 	return done
 }
 
+// handle acts on err as the error handler decides.  it reports whether the
+// relayer should keep running.
+func (mr *messageRelayer) handle(ctx context.Context, err error) bool {
+	action := mr.handleErr(err)
+	utils.DPrintf("%s: %s\n", action, err.Error())
+
+	switch action {
+	case StopRelayer:
+		mr.terminate(err)
+		return false
+	case RestartNetwork:
+		mr.lifecycle.set(Restarting, nil)
+		if rerr := mr.restartPolicy.Restart(ctx, mr.network); rerr != nil {
+			if ctx.Err() != nil {
+				// shutting down, not a failure
+				return false
+			}
+			mr.terminate(fmt.Errorf("restart network: %w", rerr))
+			return false
+		}
+		mr.lifecycle.set(Running, nil)
+	}
+
+	return true
+}
+
+func (mr *messageRelayer) terminate(err error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	mr.err = err
+}
+
 func (mr *messageRelayer) notify(ctx context.Context, msgCh <-chan domain.Message) <-chan struct{} {
 	done := make(chan struct{})
 
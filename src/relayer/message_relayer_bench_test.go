@@ -0,0 +1,92 @@
+package relayer
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mstreet3/message-relayer/domain"
+	queue "github.com/mstreet3/message-relayer/mailbox"
+	"github.com/mstreet3/message-relayer/network"
+)
+
+// instantSocket has a message ready the moment it is read, so the relayer
+// alone limits throughput.  it signals done once n messages are read.
+type instantSocket struct {
+	n    int64
+	read atomic.Int64
+	done chan struct{}
+}
+
+func newInstantSocket(n int) *instantSocket {
+	return &instantSocket{
+		n:    int64(n),
+		done: make(chan struct{}),
+	}
+}
+
+func (s *instantSocket) Read() (*domain.Message, error) {
+	if s.read.Add(1) == s.n {
+		close(s.done)
+	}
+	msg := domain.NewMessage(domain.StartNewRound, nil)
+	return &msg, nil
+}
+
+func (s *instantSocket) Restart() error {
+	return nil
+}
+
+// streamingSocket pushes the messages of an instantSocket.
+type streamingSocket struct {
+	*instantSocket
+}
+
+func (s streamingSocket) Stream(ctx context.Context) <-chan network.NetworkResponse {
+	responses := make(chan network.NetworkResponse)
+
+	go func() {
+		defer close(responses)
+		for {
+			msg, err := s.Read()
+			select {
+			case <-ctx.Done():
+				return
+			case responses <- network.NetworkResponse{Message: msg, Error: err}:
+			}
+		}
+	}()
+
+	return responses
+}
+
+func benchmarkRelayer(b *testing.B, socket network.RestartNetworkReader, done <-chan struct{}) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		mr          = newRelayer(b, socket, queue.NewPriorityMailbox(nil))
+	)
+
+	b.ResetTimer()
+	start := time.Now()
+	terminated := mr.Start(ctx)
+
+	<-done
+	elapsed := time.Since(start)
+	b.StopTimer()
+
+	cancel()
+	<-terminated
+
+	b.ReportMetric(float64(b.N)/elapsed.Seconds(), "msgs/s")
+}
+
+func BenchmarkMessageRelayer_polling(b *testing.B) {
+	socket := newInstantSocket(b.N)
+	benchmarkRelayer(b, socket, socket.done)
+}
+
+func BenchmarkMessageRelayer_streaming(b *testing.B) {
+	socket := newInstantSocket(b.N)
+	benchmarkRelayer(b, streamingSocket{socket}, socket.done)
+}
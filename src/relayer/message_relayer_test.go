@@ -3,9 +3,13 @@ package relayer
 import (
 	"context"
 	"errors"
+	"net"
 	"testing"
+	"time"
 
+	"github.com/mstreet3/message-relayer/codec"
 	"github.com/mstreet3/message-relayer/domain"
+	"github.com/mstreet3/message-relayer/errs"
 	queue "github.com/mstreet3/message-relayer/mailbox"
 	"github.com/mstreet3/message-relayer/network"
 	lfq "github.com/mstreet3/message-relayer/queues/lifoqueue"
@@ -27,8 +31,24 @@ var (
 	NetworkErrorResponse = network.NetworkResponse{
 		Error: errors.New("network unavailable"),
 	}
+	NetworkFatalResponse = network.NetworkResponse{
+		Error: errs.FatalSocketError{},
+	}
+	heartbeat = domain.MustRegisterMessageType("Test_MessageRelayer_Heartbeat", 5, domain.KeepNewest())
 )
 
+func newRelayer(
+	tb testing.TB,
+	n network.RestartNetworkReader,
+	mb mailbox[domain.Message],
+	opts ...Option,
+) *messageRelayer {
+	tb.Helper()
+	mr, err := NewMessageRelayer(n, mb, NewMessageObserverManager(), opts...)
+	require.NoError(tb, err)
+	return mr
+}
+
 func Test_MessageRelayer_RelaysMessages(t *testing.T) {
 	var (
 		ctx, cancel = context.WithCancel(context.Background())
@@ -43,11 +63,7 @@ func Test_MessageRelayer_RelaysMessages(t *testing.T) {
 
 		lifo = lfq.NewLIFOQueue[domain.Message]()
 
-		mr = NewMessageRelayer(
-			socket,
-			queue.NewMessageMailbox(1, lifo),
-			NewMessageObserverManager(),
-		)
+		mr      = newRelayer(t, socket, queue.NewMessageMailbox(nil, lifo))
 		wantSNR = 6
 		wantRA  = 3
 		gotSNR  = 0
@@ -57,8 +73,8 @@ func Test_MessageRelayer_RelaysMessages(t *testing.T) {
 	terminated := mr.Start(ctx)
 
 	// subscribe to the relayer
-	snrCh, _ := mr.Subscribe(domain.StartNewRound)
-	raCh, _ := mr.Subscribe(domain.ReceivedAnswer)
+	snrCh, _ := mr.Subscribe(context.Background(), domain.StartNewRound)
+	raCh, _ := mr.Subscribe(context.Background(), domain.ReceivedAnswer)
 
 	// read from the subscribers
 	takeSNR := utils.TakeN(ctx.Done(), snrCh, wantSNR)
@@ -99,3 +115,215 @@ func Test_MessageRelayer_RelaysMessages(t *testing.T) {
 	_, open = <-snrCh
 	require.False(t, open)
 }
+
+func Test_MessageRelayer_RelaysMessages_priority(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		responses   = []network.NetworkResponse{
+			StartNewRoundResponse,
+			ReceivedAnswerResponse,
+			StartNewRoundResponse,
+			NetworkErrorResponse,
+		}
+		socket  = network.NewNetworkSocketStub(responses)
+		mr      = newRelayer(t, socket, queue.NewPriorityMailbox(nil))
+		wantSNR = 4
+		wantRA  = 2
+		gotSNR  = 0
+		gotRA   = 0
+	)
+
+	terminated := mr.Start(ctx)
+
+	snrCh, _ := mr.Subscribe(context.Background(), domain.StartNewRound)
+	raCh, _ := mr.Subscribe(context.Background(), domain.ReceivedAnswer)
+
+	takeSNR := utils.TakeN(ctx.Done(), snrCh, wantSNR)
+	takeRA := utils.TakeN(ctx.Done(), raCh, wantRA)
+	doneSNR := make(chan struct{})
+	doneRA := make(chan struct{})
+
+	go func() {
+		defer close(doneSNR)
+		for msg := range takeSNR {
+			require.Equal(t, domain.StartNewRound, msg.Type())
+			gotSNR++
+		}
+	}()
+
+	go func() {
+		defer close(doneRA)
+		for msg := range takeRA {
+			require.Equal(t, domain.ReceivedAnswer, msg.Type())
+			gotRA++
+		}
+	}()
+
+	go func() {
+		defer cancel()
+		<-doneSNR
+		<-doneRA
+	}()
+
+	<-terminated
+
+	require.Equal(t, wantSNR, gotSNR)
+	require.Equal(t, wantRA, gotRA)
+
+	_, open := <-snrCh
+	require.False(t, open)
+
+	_, open = <-raCh
+	require.False(t, open)
+}
+
+func Test_MessageRelayer_RelaysRegisteredTypes(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		msg         = domain.NewMessage(heartbeat, []byte("alive"))
+		socket      = network.NewNetworkSocketStub([]network.NetworkResponse{
+			{Message: &msg},
+		})
+		mr   = newRelayer(t, socket, queue.NewPriorityMailbox(nil))
+		want = 3
+		got  = 0
+	)
+
+	terminated := mr.Start(ctx)
+
+	hbCh, _ := mr.Subscribe(context.Background(), heartbeat)
+
+	go func() {
+		defer cancel()
+		for msg := range utils.TakeN(ctx.Done(), hbCh, want) {
+			require.Equal(t, heartbeat, msg.Type())
+			require.Equal(t, []byte("alive"), msg.Data)
+			got++
+		}
+	}()
+
+	<-terminated
+
+	require.Equal(t, want, got)
+}
+
+func Test_MessageRelayer_SubscriptionEndsWithContext(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		msg         = domain.NewMessage(heartbeat, nil)
+		socket      = network.NewNetworkSocketStub([]network.NetworkResponse{
+			{Message: &msg},
+		})
+		mr = newRelayer(t, socket, queue.NewPriorityMailbox(nil))
+	)
+	defer cancel()
+
+	subCtx, unsubscribe := context.WithCancel(ctx)
+	hbCh, _ := mr.Subscribe(subCtx, heartbeat)
+
+	terminated := mr.Start(ctx)
+
+	<-hbCh
+	unsubscribe()
+
+	// the subscription ends while the relayer keeps running
+	for range hbCh {
+	}
+	require.Empty(t, mr.om.Stats())
+	require.Equal(t, Running, mr.State())
+
+	cancel()
+	<-terminated
+}
+
+func Test_MessageRelayer_stops_with_a_stalled_blocking_subscriber(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		fake        = &fakeSocket{read: readMessages}
+		mr          = newRelayer(t, fake, queue.NewPriorityMailbox(nil), WithReadInterval(time.Millisecond))
+	)
+	defer cancel()
+
+	// nobody receives, so Notify waits on the full subscription
+	_, _ = mr.Subscribe(context.Background(), domain.StartNewRound, WithBlockingDelivery(0))
+	terminated := mr.Start(ctx)
+
+	require.Eventually(t, func() bool {
+		return fake.reads.Load() > 10
+	}, time.Second, time.Millisecond)
+	cancel()
+
+	select {
+	case <-terminated:
+	case <-time.After(time.Second):
+		t.Fatal("a stalled subscriber kept the relayer from stopping")
+	}
+	require.Equal(t, Stopped, mr.State())
+}
+
+func Test_MessageRelayer_EmptiesMailboxOnAdd(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		mb          = queue.NewPriorityMailbox(nil)
+		// the network is never polled, so only the mailbox being ready empties it
+		mr = newRelayer(t, network.NewNetworkSocketStub(nil), mb, WithReadInterval(time.Hour))
+	)
+	defer cancel()
+
+	hbCh, _ := mr.Subscribe(context.Background(), heartbeat, WithBufferedDelivery(1))
+	terminated := mr.Start(ctx)
+
+	mb.Add(domain.NewMessage(heartbeat, []byte("now")))
+
+	select {
+	case msg := <-hbCh:
+		require.Equal(t, []byte("now"), msg.Data)
+	case <-time.After(time.Second):
+		t.Fatal("message waited for a read")
+	}
+
+	cancel()
+	<-terminated
+}
+
+func Test_MessageRelayer_RestartsTCPReader(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	// every connection sends one round and then drops
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = codec.Encode(conn, domain.NewMessage(domain.StartNewRound, nil))
+			conn.Close()
+		}
+	}()
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		socket      = network.NewTCPNetworkReader(network.TCPConfig{Addr: l.Addr().String()})
+		mr          = newRelayer(t, socket, queue.NewPriorityMailbox(nil))
+		want        = 3
+		got         = 0
+	)
+	defer socket.Close()
+
+	terminated := mr.Start(ctx)
+
+	snrCh, _ := mr.Subscribe(context.Background(), domain.StartNewRound)
+
+	go func() {
+		defer cancel()
+		for range utils.TakeN(ctx.Done(), snrCh, want) {
+			got++
+		}
+	}()
+
+	<-terminated
+
+	require.Equal(t, want, got)
+}
@@ -0,0 +1,109 @@
+package relayer
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mstreet3/message-relayer/errs"
+)
+
+const (
+	DefaultReadInterval     = 80 * time.Millisecond
+	DefaultHeartbeatTimeout = time.Duration(0) // disabled
+)
+
+var (
+	ErrInvalidReadInterval     = errors.New("relayer: read interval must be positive")
+	ErrInvalidHeartbeatTimeout = errors.New("relayer: heartbeat timeout must not be negative")
+	ErrNilRestartPolicy        = errors.New("relayer: restart policy is nil")
+	ErrNilErrorHandler         = errors.New("relayer: error handler is nil")
+
+	// ErrHeartbeatTimeout is handed to the ErrorHandler when the network
+	// delivers no message within the heartbeat timeout.
+	ErrHeartbeatTimeout = errors.New("relayer: no message read within heartbeat timeout")
+)
+
+// Option configures a message relayer.  an option returns an error if its
+// value is invalid.
+type Option func(*messageRelayer) error
+
+// WithReadInterval sets the pulse at which the network is polled and the
+// heartbeat timeout is checked.
+func WithReadInterval(d time.Duration) Option {
+	return func(mr *messageRelayer) error {
+		if d <= 0 {
+			return ErrInvalidReadInterval
+		}
+		mr.pulse = d
+		return nil
+	}
+}
+
+// WithHeartbeatTimeout sets the longest the network may go without
+// delivering a message before ErrHeartbeatTimeout is handled.  zero
+// disables the timeout.
+func WithHeartbeatTimeout(d time.Duration) Option {
+	return func(mr *messageRelayer) error {
+		if d < 0 {
+			return ErrInvalidHeartbeatTimeout
+		}
+		mr.heartbeatTimeout = d
+		return nil
+	}
+}
+
+// WithRestartPolicy sets how the network is restarted.
+func WithRestartPolicy(p RestartPolicy) Option {
+	return func(mr *messageRelayer) error {
+		if p == nil {
+			return ErrNilRestartPolicy
+		}
+		mr.restartPolicy = p
+		return nil
+	}
+}
+
+// WithErrorHandler sets how errors from the network are handled.
+func WithErrorHandler(h ErrorHandler) Option {
+	return func(mr *messageRelayer) error {
+		if h == nil {
+			return ErrNilErrorHandler
+		}
+		mr.handleErr = h
+		return nil
+	}
+}
+
+// ErrorAction is what the relayer does about an error.
+type ErrorAction int
+
+const (
+	IgnoreError    ErrorAction = iota // keep reading
+	RestartNetwork                    // restart the network with the restart policy
+	StopRelayer                       // shut the relayer down
+)
+
+func (a ErrorAction) String() string {
+	switch a {
+	case IgnoreError:
+		return "IgnoreError"
+	case RestartNetwork:
+		return "RestartNetwork"
+	case StopRelayer:
+		return "StopRelayer"
+	default:
+		return "Unknown"
+	}
+}
+
+// ErrorHandler decides what to do about an error.
+type ErrorHandler func(error) ErrorAction
+
+// DefaultErrorHandler restarts the network on a FatalSocketError or a
+// heartbeat timeout and ignores every other error.
+func DefaultErrorHandler(err error) ErrorAction {
+	if errors.Is(err, errs.FatalSocketError{}) || errors.Is(err, ErrHeartbeatTimeout) {
+		return RestartNetwork
+	}
+	return IgnoreError
+}
@@ -0,0 +1,202 @@
+package relayer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mstreet3/message-relayer/domain"
+	"github.com/mstreet3/message-relayer/errs"
+	queue "github.com/mstreet3/message-relayer/mailbox"
+	"github.com/mstreet3/message-relayer/network"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSocket reads with read and counts how often it is read and restarted.
+type fakeSocket struct {
+	read     func() (*domain.Message, error)
+	reads    atomic.Int64
+	restarts atomic.Int64
+}
+
+func (s *fakeSocket) Read() (*domain.Message, error) {
+	s.reads.Add(1)
+	return s.read()
+}
+
+func (s *fakeSocket) Restart() error {
+	s.restarts.Add(1)
+	return nil
+}
+
+func readMessages() (*domain.Message, error) {
+	msg := domain.NewMessage(domain.StartNewRound, nil)
+	return &msg, nil
+}
+
+func readErrors(err error) func() (*domain.Message, error) {
+	return func() (*domain.Message, error) {
+		return nil, err
+	}
+}
+
+// restartSignal is a restart policy that restarts the network and closes
+// restarted on its first use.
+func restartSignal(restarted chan struct{}) RestartPolicy {
+	var once atomic.Bool
+	return RestartPolicyFunc(func(ctx context.Context, r network.Restarter) error {
+		if once.CompareAndSwap(false, true) {
+			close(restarted)
+		}
+		return r.Restart()
+	})
+}
+
+func Test_NewMessageRelayer_validates_options(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+		want error
+	}{
+		{"zero read interval", WithReadInterval(0), ErrInvalidReadInterval},
+		{"negative read interval", WithReadInterval(-time.Second), ErrInvalidReadInterval},
+		{"negative heartbeat timeout", WithHeartbeatTimeout(-time.Second), ErrInvalidHeartbeatTimeout},
+		{"nil restart policy", WithRestartPolicy(nil), ErrNilRestartPolicy},
+		{"nil error handler", WithErrorHandler(nil), ErrNilErrorHandler},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr, err := NewMessageRelayer(
+				&fakeSocket{read: readMessages},
+				queue.NewPriorityMailbox(nil),
+				NewMessageObserverManager(),
+				tt.opt,
+			)
+			require.ErrorIs(t, err, tt.want)
+			require.Nil(t, mr)
+		})
+	}
+}
+
+func Test_NewMessageRelayer_defaults(t *testing.T) {
+	mr := newRelayer(t, &fakeSocket{read: readMessages}, queue.NewPriorityMailbox(nil))
+
+	require.Equal(t, DefaultReadInterval, mr.pulse)
+	require.Equal(t, DefaultHeartbeatTimeout, mr.heartbeatTimeout)
+	require.Equal(t, RestartNetwork, mr.handleErr(fmt.Errorf("read: %w", errs.FatalSocketError{})))
+	require.Equal(t, RestartNetwork, mr.handleErr(ErrHeartbeatTimeout))
+	require.Equal(t, IgnoreError, mr.handleErr(errors.New("network unavailable")))
+}
+
+func Test_WithReadInterval_sets_polling_pulse(t *testing.T) {
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+		socket      = &fakeSocket{read: readMessages}
+		mr          = newRelayer(t, socket, queue.NewPriorityMailbox(nil),
+			WithReadInterval(5*time.Millisecond),
+		)
+	)
+	defer cancel()
+
+	<-mr.Start(ctx)
+
+	// the default pulse polls at most 3 times in 200ms
+	require.Greater(t, socket.reads.Load(), int64(10))
+}
+
+func Test_WithHeartbeatTimeout_restarts_stalled_network(t *testing.T) {
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		restarted   = make(chan struct{})
+		socket      = &fakeSocket{read: readErrors(errors.New("no data"))}
+		mr          = newRelayer(t, socket, queue.NewPriorityMailbox(nil),
+			WithReadInterval(5*time.Millisecond),
+			WithHeartbeatTimeout(50*time.Millisecond),
+			WithRestartPolicy(restartSignal(restarted)),
+		)
+	)
+	defer cancel()
+
+	terminated := mr.Start(ctx)
+
+	select {
+	case <-restarted:
+	case <-ctx.Done():
+		t.Fatal("stalled network was not restarted")
+	}
+
+	cancel()
+	<-terminated
+	require.GreaterOrEqual(t, socket.restarts.Load(), int64(1))
+}
+
+func Test_WithHeartbeatTimeout_ignores_live_network(t *testing.T) {
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+		socket      = &fakeSocket{read: readMessages}
+		mr          = newRelayer(t, socket, queue.NewPriorityMailbox(nil),
+			WithReadInterval(5*time.Millisecond),
+			WithHeartbeatTimeout(50*time.Millisecond),
+		)
+	)
+	defer cancel()
+
+	<-mr.Start(ctx)
+
+	require.Equal(t, int64(0), socket.restarts.Load())
+}
+
+func Test_WithErrorHandler_stops_relayer(t *testing.T) {
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		errStop     = errors.New("stop")
+		handled     = make(chan error, 1)
+		socket      = &fakeSocket{read: readErrors(errStop)}
+		mr          = newRelayer(t, socket, queue.NewPriorityMailbox(nil),
+			WithReadInterval(5*time.Millisecond),
+			WithErrorHandler(func(err error) ErrorAction {
+				select {
+				case handled <- err:
+				default:
+				}
+				return StopRelayer
+			}),
+		)
+	)
+	defer cancel()
+
+	select {
+	case <-mr.Start(ctx):
+	case <-ctx.Done():
+		t.Fatal("relayer did not stop")
+	}
+
+	require.ErrorIs(t, <-handled, errStop)
+	require.ErrorIs(t, mr.Err(), errStop)
+	require.NoError(t, ctx.Err())
+}
+
+func Test_WithErrorHandler_ignores_fatal_errors(t *testing.T) {
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+		socket      = network.NewNetworkSocketStub(nil)
+		restarts    atomic.Int64
+		mr          = newRelayer(t, socket, queue.NewPriorityMailbox(nil),
+			WithReadInterval(5*time.Millisecond),
+			WithErrorHandler(func(error) ErrorAction { return IgnoreError }),
+			WithRestartPolicy(RestartPolicyFunc(func(context.Context, network.Restarter) error {
+				restarts.Add(1)
+				return nil
+			})),
+		)
+	)
+	defer cancel()
+
+	<-mr.Start(ctx)
+
+	require.Equal(t, int64(0), restarts.Load())
+}
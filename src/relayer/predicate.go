@@ -0,0 +1,51 @@
+package relayer
+
+import "github.com/mstreet3/message-relayer/domain"
+
+// MessagePredicate selects the messages delivered to a filtered
+// subscription.
+type MessagePredicate = func(domain.Message) bool
+
+// OfTypes matches messages of any of the given types.
+func OfTypes(mts ...domain.MessageType) MessagePredicate {
+	set := make(map[domain.MessageType]struct{}, len(mts))
+	for _, mt := range mts {
+		set[mt] = struct{}{}
+	}
+
+	return func(msg domain.Message) bool {
+		_, ok := set[msg.Type()]
+		return ok
+	}
+}
+
+// And matches messages that every predicate matches.
+func And(preds ...MessagePredicate) MessagePredicate {
+	return func(msg domain.Message) bool {
+		for _, p := range preds {
+			if !p(msg) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or matches messages that any predicate matches.
+func Or(preds ...MessagePredicate) MessagePredicate {
+	return func(msg domain.Message) bool {
+		for _, p := range preds {
+			if p(msg) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not matches messages that pred does not.
+func Not(pred MessagePredicate) MessagePredicate {
+	return func(msg domain.Message) bool {
+		return !pred(msg)
+	}
+}
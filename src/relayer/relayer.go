@@ -6,10 +6,21 @@ import (
 	"github.com/mstreet3/message-relayer/domain"
 )
 
+// Subscriber delivers the values of a topic until ctx is done or the
+// returned cleanup func is called.
 type Subscriber[T interface{}, U interface{}] interface {
-	Subscribe(T) (<-chan U, func())
+	Subscribe(context.Context, T, ...SubscribeOption) (<-chan U, func())
 }
 type MessageRelayer interface {
 	Subscriber[domain.MessageType, domain.Message]
+	SubscribeWhere(context.Context, MessagePredicate, ...SubscribeOption) (<-chan domain.Message, func())
+	SubscribeAll(context.Context, ...SubscribeOption) (<-chan domain.Message, func())
+	Await(context.Context, MessagePredicate) (domain.Message, error)
+	Request(context.Context, func(correlationID string) error, ...MessagePredicate) (domain.Message, error)
 	Start(context.Context) <-chan struct{}
+	Wait() error
+	Err() error
+	State() State
+	States() <-chan StateChange
+	Duplicates() uint64
 }
@@ -0,0 +1,75 @@
+package relayer
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/mstreet3/message-relayer/domain"
+)
+
+// replayed is a notified message along with the order it was notified in.
+type replayed struct {
+	seq uint64
+	msg domain.Message
+}
+
+// replayBuffer keeps the most recent messages notified of a single type.
+type replayBuffer struct {
+	mu   sync.Mutex
+	size int
+	msgs []replayed
+}
+
+func newReplayBuffer(size int) *replayBuffer {
+	return &replayBuffer{
+		size: size,
+		msgs: make([]replayed, 0, size),
+	}
+}
+
+// record keeps msg and evicts the oldest message once the buffer is full.
+func (b *replayBuffer) record(seq uint64, msg domain.Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.msgs) == b.size {
+		copy(b.msgs, b.msgs[1:])
+		b.msgs = b.msgs[:len(b.msgs)-1]
+	}
+	b.msgs = append(b.msgs, replayed{seq: seq, msg: msg})
+}
+
+// snapshot returns the buffered messages, oldest first.
+func (b *replayBuffer) snapshot() []replayed {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]replayed(nil), b.msgs...)
+}
+
+// lastN returns the last n messages of buffers that match, in the order
+// they were notified.
+func lastN(buffers []*replayBuffer, match MessagePredicate, n int) []domain.Message {
+	all := make([]replayed, 0)
+	for _, b := range buffers {
+		for _, r := range b.snapshot() {
+			if match(r.msg) {
+				all = append(all, r)
+			}
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].seq < all[j].seq
+	})
+
+	if len(all) > n {
+		all = all[len(all)-n:]
+	}
+
+	msgs := make([]domain.Message, 0, len(all))
+	for _, r := range all {
+		msgs = append(msgs, r.msg)
+	}
+	return msgs
+}
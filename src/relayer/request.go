@@ -0,0 +1,62 @@
+package relayer
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/mstreet3/message-relayer/domain"
+)
+
+// ErrSubscriptionEnded is returned by Await when the subscription ends
+// before a message matches, as it does when the relayer stops.
+var ErrSubscriptionEnded = errors.New("subscription ended before a matching message")
+
+// WithCorrelationID matches messages that carry the correlation id id.
+func WithCorrelationID(id string) MessagePredicate {
+	return func(msg domain.Message) bool {
+		return msg.CorrelationID == id
+	}
+}
+
+// Await returns the first message that matches pred.  it gives up with
+// ctx.Err() once ctx is done, so a deadline on ctx bounds the wait.
+func (mr *messageRelayer) Await(ctx context.Context, pred MessagePredicate) (domain.Message, error) {
+	msgCh, cleanup := mr.om.SubscribeWhere(ctx, pred, WithBlockingDelivery(0))
+	defer cleanup()
+
+	return receive(ctx, msgCh)
+}
+
+// Request sends a request with send and returns its reply: the first
+// message that carries the request's correlation id and matches preds.
+// the subscription is made before send is called, so a reply cannot be
+// missed.  it gives up with ctx.Err() once ctx is done.
+func (mr *messageRelayer) Request(ctx context.Context, send func(correlationID string) error, preds ...MessagePredicate) (domain.Message, error) {
+	id := uuid.NewString()
+
+	msgCh, cleanup := mr.om.SubscribeWhere(ctx, And(WithCorrelationID(id), And(preds...)), WithBlockingDelivery(0))
+	defer cleanup()
+
+	if err := send(id); err != nil {
+		return domain.Message{}, err
+	}
+
+	return receive(ctx, msgCh)
+}
+
+// receive returns the first message on msgCh.
+func receive(ctx context.Context, msgCh <-chan domain.Message) (domain.Message, error) {
+	select {
+	case <-ctx.Done():
+		return domain.Message{}, ctx.Err()
+	case msg, open := <-msgCh:
+		if !open {
+			if err := ctx.Err(); err != nil {
+				return domain.Message{}, err
+			}
+			return domain.Message{}, ErrSubscriptionEnded
+		}
+		return msg, nil
+	}
+}
@@ -0,0 +1,123 @@
+package relayer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mstreet3/message-relayer/domain"
+	queue "github.com/mstreet3/message-relayer/mailbox"
+	"github.com/mstreet3/message-relayer/network"
+	"github.com/stretchr/testify/require"
+)
+
+func reply(mt domain.MessageType, id string, data string) domain.Message {
+	msg := domain.NewMessage(mt, []byte(data))
+	msg.CorrelationID = id
+	return msg
+}
+
+func Test_Await_returns_first_matching_message(t *testing.T) {
+	mr := newRelayer(t, network.NewNetworkSocketStub(nil), queue.NewPriorityMailbox(nil))
+	defer mr.om.Close()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		mr.om.Notify(context.Background(), reply(domain.StartNewRound, "round-1", "start"))
+		mr.om.Notify(context.Background(), reply(domain.ReceivedAnswer, "round-2", "other"))
+		mr.om.Notify(context.Background(), reply(domain.ReceivedAnswer, "round-1", "first"))
+		mr.om.Notify(context.Background(), reply(domain.ReceivedAnswer, "round-1", "second"))
+	}()
+
+	msg, err := mr.Await(context.Background(), And(OfTypes(domain.ReceivedAnswer), WithCorrelationID("round-1")))
+	require.NoError(t, err)
+	require.Equal(t, []byte("first"), msg.Data)
+
+	// the subscription is cleaned up
+	require.Empty(t, mr.om.Stats())
+}
+
+func Test_Await_gives_up(t *testing.T) {
+	mr := newRelayer(t, network.NewNetworkSocketStub(nil), queue.NewPriorityMailbox(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := mr.Await(ctx, OfTypes(domain.ReceivedAnswer))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Empty(t, mr.om.Stats())
+
+	// a stopped relayer ends the subscription
+	mr.om.Close()
+	_, err = mr.Await(context.Background(), OfTypes(domain.ReceivedAnswer))
+	require.ErrorIs(t, err, ErrSubscriptionEnded)
+}
+
+func Test_Request_returns_correlated_reply(t *testing.T) {
+	mr := newRelayer(t, network.NewNetworkSocketStub(nil), queue.NewPriorityMailbox(nil))
+	defer mr.om.Close()
+
+	msg, err := mr.Request(context.Background(), func(id string) error {
+		require.NotEmpty(t, id)
+
+		// replies that arrive before send returns are not missed
+		mr.om.Notify(context.Background(), reply(domain.ReceivedAnswer, "someone-else", "other"))
+		mr.om.Notify(context.Background(), reply(domain.StartNewRound, id, "start"))
+		mr.om.Notify(context.Background(), reply(domain.ReceivedAnswer, id, "answer"))
+		return nil
+	}, OfTypes(domain.ReceivedAnswer))
+
+	require.NoError(t, err)
+	require.Equal(t, []byte("answer"), msg.Data)
+	require.Empty(t, mr.om.Stats())
+}
+
+func Test_Request_returns_send_error(t *testing.T) {
+	var (
+		mr      = newRelayer(t, network.NewNetworkSocketStub(nil), queue.NewPriorityMailbox(nil))
+		errSend = errors.New("send failed")
+	)
+	defer mr.om.Close()
+
+	_, err := mr.Request(context.Background(), func(string) error {
+		return errSend
+	})
+
+	require.ErrorIs(t, err, errSend)
+	require.Empty(t, mr.om.Stats())
+}
+
+func Test_Request_relays_reply_from_network(t *testing.T) {
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		sent        = make(chan string, 1)
+		replies     = make(chan domain.Message, 1)
+		socket      = &fakeSocket{read: func() (*domain.Message, error) {
+			select {
+			case msg := <-replies:
+				return &msg, nil
+			default:
+				return nil, errors.New("no reply yet")
+			}
+		}}
+		mr = newRelayer(t, socket, queue.NewPriorityMailbox(nil), WithReadInterval(time.Millisecond))
+	)
+	defer cancel()
+
+	terminated := mr.Start(ctx)
+
+	go func() {
+		replies <- reply(domain.ReceivedAnswer, <-sent, "answer")
+	}()
+
+	msg, err := mr.Request(ctx, func(id string) error {
+		sent <- id
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte("answer"), msg.Data)
+
+	cancel()
+	<-terminated
+}
@@ -0,0 +1,216 @@
+package relayer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mstreet3/message-relayer/network"
+)
+
+var ErrRestartAttemptsExhausted = errors.New("relayer: restart attempts exhausted")
+
+// RestartPolicy restarts a network that has failed.  an error means the
+// policy has given up and the relayer terminates with that error.  the
+// readers of the network package dial once per Restart, so the policy is
+// the only layer that retries and backs off.
+type RestartPolicy interface {
+	Restart(ctx context.Context, r network.Restarter) error
+}
+
+type RestartPolicyFunc func(context.Context, network.Restarter) error
+
+func (f RestartPolicyFunc) Restart(ctx context.Context, r network.Restarter) error {
+	return f(ctx, r)
+}
+
+// RestartImmediately restarts the network once, without delay.
+var RestartImmediately RestartPolicy = RestartPolicyFunc(func(_ context.Context, r network.Restarter) error {
+	return r.Restart()
+})
+
+// BackoffConfig configures a BackoffPolicy.  zero values take the defaults
+// noted on each field.
+type BackoffConfig struct {
+	InitialDelay time.Duration // before the second attempt, default 100ms
+	MaxDelay     time.Duration // cap on the delay between attempts, default 10s
+	Multiplier   float64       // delay growth per attempt, default 2
+	Jitter       float64       // fraction of each delay randomised either way, 0 to 1
+	MaxAttempts  int           // attempts per restart, default 5
+
+	// the circuit breaker opens once more than FailureThreshold attempts
+	// fail within Window, and holds off attempts for Cooldown.  then a
+	// single attempt is let through: a success closes the breaker and a
+	// failure opens it again.  a zero threshold disables the breaker.
+	FailureThreshold int
+	Window           time.Duration // default 1m
+	Cooldown         time.Duration // default 30s
+}
+
+func (c BackoffConfig) withDefaults() BackoffConfig {
+	if c.InitialDelay <= 0 {
+		c.InitialDelay = 100 * time.Millisecond
+	}
+	if c.MaxDelay < c.InitialDelay {
+		c.MaxDelay = 10 * time.Second
+		if c.MaxDelay < c.InitialDelay {
+			c.MaxDelay = c.InitialDelay
+		}
+	}
+	if c.Multiplier < 1 {
+		c.Multiplier = 2
+	}
+	if c.Jitter < 0 {
+		c.Jitter = 0
+	}
+	if c.Jitter > 1 {
+		c.Jitter = 1
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 5
+	}
+	if c.Window <= 0 {
+		c.Window = time.Minute
+	}
+	if c.Cooldown <= 0 {
+		c.Cooldown = 30 * time.Second
+	}
+	return c
+}
+
+// BackoffPolicy retries a restart with exponentially growing, jittered
+// delays.  it gives up once MaxAttempts fail in a row.  while its circuit
+// breaker is open the next attempt waits for the cooldown.  a policy is
+// meant to be used by one relayer at a time.
+type BackoffPolicy struct {
+	cfg       BackoffConfig
+	mu        sync.Mutex
+	failures  []time.Time // failed attempts within the window
+	openUntil time.Time
+	halfOpen  bool // the breaker opens again on the next failure
+	rand      *rand.Rand
+	now       func() time.Time
+	sleep     func(context.Context, time.Duration) error
+}
+
+var _ RestartPolicy = (*BackoffPolicy)(nil)
+
+func NewBackoffPolicy(cfg BackoffConfig) *BackoffPolicy {
+	return &BackoffPolicy{
+		cfg:   cfg.withDefaults(),
+		mu:    sync.Mutex{},
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		now:   time.Now,
+		sleep: sleep,
+	}
+}
+
+func (p *BackoffPolicy) Restart(ctx context.Context, r network.Restarter) error {
+	var err error
+	for attempt := 1; attempt <= p.cfg.MaxAttempts; attempt++ {
+		if d := p.cooldown(); d > 0 {
+			if serr := p.sleep(ctx, d); serr != nil {
+				return serr
+			}
+		}
+
+		if err = r.Restart(); err == nil {
+			p.succeeded()
+			return nil
+		}
+		p.failed()
+
+		if attempt < p.cfg.MaxAttempts {
+			if serr := p.sleep(ctx, p.delay(attempt)); serr != nil {
+				return serr
+			}
+		}
+	}
+
+	return fmt.Errorf("%w after %d attempts: %v", ErrRestartAttemptsExhausted, p.cfg.MaxAttempts, err)
+}
+
+// cooldown returns how long the breaker stays open, or zero while it is
+// closed.
+func (p *BackoffPolicy) cooldown() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if d := p.openUntil.Sub(p.now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// succeeded closes the breaker.
+func (p *BackoffPolicy) succeeded() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failures = p.failures[:0]
+	p.halfOpen = false
+}
+
+// failed records a failed attempt and opens the breaker once more than
+// FailureThreshold attempts failed within the window, or once the attempt
+// let through after a cooldown failed.
+func (p *BackoffPolicy) failed() {
+	if p.cfg.FailureThreshold <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+
+	// forget failures that fell out of the window
+	recent := p.failures[:0]
+	for _, t := range p.failures {
+		if now.Sub(t) < p.cfg.Window {
+			recent = append(recent, t)
+		}
+	}
+	p.failures = append(recent, now)
+
+	if p.halfOpen || len(p.failures) > p.cfg.FailureThreshold {
+		p.failures = p.failures[:0]
+		p.openUntil = now.Add(p.cfg.Cooldown)
+		p.halfOpen = true
+	}
+}
+
+// delay returns the jittered wait after the given failed attempt.
+func (p *BackoffPolicy) delay(attempt int) time.Duration {
+	d := float64(p.cfg.InitialDelay)
+	for i := 1; i < attempt; i++ {
+		d *= p.cfg.Multiplier
+		if d >= float64(p.cfg.MaxDelay) {
+			d = float64(p.cfg.MaxDelay)
+			break
+		}
+	}
+
+	if p.cfg.Jitter > 0 {
+		p.mu.Lock()
+		d += d * p.cfg.Jitter * (2*p.rand.Float64() - 1)
+		p.mu.Unlock()
+	}
+
+	return time.Duration(d)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
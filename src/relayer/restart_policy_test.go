@@ -0,0 +1,250 @@
+package relayer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mstreet3/message-relayer/domain"
+	queue "github.com/mstreet3/message-relayer/mailbox"
+	"github.com/stretchr/testify/require"
+)
+
+var errDialFailed = errors.New("dial failed")
+
+// flakyRestarter fails its first n restarts.
+type flakyRestarter struct {
+	n     int
+	calls int
+}
+
+func (r *flakyRestarter) Restart() error {
+	r.calls++
+	if r.calls <= r.n {
+		return errDialFailed
+	}
+	return nil
+}
+
+// newTestBackoffPolicy records the delays it would sleep instead of
+// sleeping.
+func newTestBackoffPolicy(cfg BackoffConfig) (*BackoffPolicy, *[]time.Duration) {
+	var (
+		p      = NewBackoffPolicy(cfg)
+		delays = make([]time.Duration, 0)
+	)
+
+	p.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return ctx.Err()
+	}
+
+	return p, &delays
+}
+
+func Test_BackoffPolicy_retries_with_exponential_backoff(t *testing.T) {
+	var (
+		p, delays = newTestBackoffPolicy(BackoffConfig{
+			InitialDelay: 10 * time.Millisecond,
+			MaxDelay:     50 * time.Millisecond,
+			MaxAttempts:  6,
+		})
+		r = &flakyRestarter{n: 5}
+	)
+
+	require.NoError(t, p.Restart(context.Background(), r))
+	require.Equal(t, 6, r.calls)
+	require.Equal(t, []time.Duration{
+		10 * time.Millisecond,
+		20 * time.Millisecond,
+		40 * time.Millisecond,
+		50 * time.Millisecond,
+		50 * time.Millisecond,
+	}, *delays)
+}
+
+func Test_BackoffPolicy_jitters_delays(t *testing.T) {
+	var (
+		p, delays = newTestBackoffPolicy(BackoffConfig{
+			InitialDelay: 100 * time.Millisecond,
+			Multiplier:   1,
+			Jitter:       0.5,
+			MaxAttempts:  50,
+		})
+		r = &flakyRestarter{n: 49}
+	)
+
+	require.NoError(t, p.Restart(context.Background(), r))
+
+	distinct := make(map[time.Duration]struct{})
+	for _, d := range *delays {
+		require.GreaterOrEqual(t, d, 50*time.Millisecond)
+		require.LessOrEqual(t, d, 150*time.Millisecond)
+		distinct[d] = struct{}{}
+	}
+	require.Greater(t, len(distinct), 1)
+}
+
+func Test_BackoffPolicy_gives_up_after_max_attempts(t *testing.T) {
+	var (
+		p, delays = newTestBackoffPolicy(BackoffConfig{MaxAttempts: 3})
+		r         = &flakyRestarter{n: 3}
+	)
+
+	err := p.Restart(context.Background(), r)
+
+	require.ErrorIs(t, err, ErrRestartAttemptsExhausted)
+	require.Contains(t, err.Error(), errDialFailed.Error())
+	require.Equal(t, 3, r.calls)
+	require.Len(t, *delays, 2)
+}
+
+func Test_BackoffPolicy_stops_when_context_is_done(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var (
+		p, _ = newTestBackoffPolicy(BackoffConfig{MaxAttempts: 3})
+		r    = &flakyRestarter{n: 3}
+	)
+
+	require.ErrorIs(t, p.Restart(ctx, r), context.Canceled)
+	require.Equal(t, 1, r.calls)
+}
+
+func Test_BackoffPolicy_circuit_breaker_ignores_successful_restarts(t *testing.T) {
+	var (
+		p, delays = newTestBackoffPolicy(BackoffConfig{FailureThreshold: 1})
+		r         = &flakyRestarter{}
+	)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, p.Restart(context.Background(), r))
+	}
+	require.Equal(t, 3, r.calls)
+	require.Empty(t, *delays)
+}
+
+func Test_BackoffPolicy_circuit_breaker(t *testing.T) {
+	var (
+		now    = time.Now()
+		delays = make([]time.Duration, 0)
+		p      = NewBackoffPolicy(BackoffConfig{
+			InitialDelay:     time.Second,
+			MaxDelay:         time.Minute,
+			MaxAttempts:      10,
+			FailureThreshold: 2,
+			Window:           time.Minute,
+			Cooldown:         30 * time.Second,
+		})
+		r = &flakyRestarter{n: 4}
+	)
+	p.now = func() time.Time { return now }
+	p.sleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		now = now.Add(d)
+		return nil
+	}
+
+	require.NoError(t, p.Restart(context.Background(), r))
+	require.Equal(t, 5, r.calls)
+	require.Equal(t, []time.Duration{
+		time.Second,
+		2 * time.Second,
+		// the third failure opens the breaker for 30s
+		4 * time.Second,
+		26 * time.Second,
+		// the attempt after the cooldown fails and opens it again
+		8 * time.Second,
+		22 * time.Second,
+	}, delays)
+
+	// the success closed the breaker
+	delays = delays[:0]
+	r.n, r.calls = 2, 0
+	require.NoError(t, p.Restart(context.Background(), r))
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second}, delays)
+}
+
+func Test_BackoffPolicy_circuit_breaker_forgets_failures_out_of_window(t *testing.T) {
+	var (
+		now    = time.Now()
+		delays = make([]time.Duration, 0)
+		p      = NewBackoffPolicy(BackoffConfig{
+			InitialDelay:     time.Second,
+			Multiplier:       1,
+			MaxAttempts:      1,
+			FailureThreshold: 1,
+			Window:           time.Minute,
+			Cooldown:         30 * time.Second,
+		})
+		r = &flakyRestarter{n: 3}
+	)
+	p.now = func() time.Time { return now }
+	p.sleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		now = now.Add(d)
+		return nil
+	}
+
+	require.ErrorIs(t, p.Restart(context.Background(), r), ErrRestartAttemptsExhausted)
+	now = now.Add(2 * time.Minute)
+	require.ErrorIs(t, p.Restart(context.Background(), r), ErrRestartAttemptsExhausted)
+	require.Empty(t, delays)
+
+	// the second failure within the window opens the breaker
+	require.ErrorIs(t, p.Restart(context.Background(), r), ErrRestartAttemptsExhausted)
+	require.NoError(t, p.Restart(context.Background(), r))
+	require.Equal(t, []time.Duration{30 * time.Second}, delays)
+	require.Equal(t, 4, r.calls)
+}
+
+// deadSocket fails every read fatally and can never be restarted.
+type deadSocket struct{}
+
+func (deadSocket) Read() (*domain.Message, error) {
+	return nil, NetworkFatalResponse.Error
+}
+
+func (deadSocket) Restart() error {
+	return errDialFailed
+}
+
+func Test_MessageRelayer_terminates_when_restart_policy_gives_up(t *testing.T) {
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		policy, _   = newTestBackoffPolicy(BackoffConfig{MaxAttempts: 2})
+		mr          = newRelayer(t, deadSocket{}, queue.NewPriorityMailbox(nil),
+			WithReadInterval(5*time.Millisecond),
+			WithRestartPolicy(policy),
+		)
+	)
+	defer cancel()
+
+	snrCh, _ := mr.Subscribe(context.Background(), domain.StartNewRound)
+	terminated := mr.Start(ctx)
+
+	select {
+	case <-terminated:
+	case <-ctx.Done():
+		t.Fatal("relayer did not terminate")
+	}
+
+	require.ErrorIs(t, mr.Err(), ErrRestartAttemptsExhausted)
+	require.NoError(t, ctx.Err())
+
+	_, open := <-snrCh
+	require.False(t, open)
+}
+
+func Test_MessageRelayer_clean_shutdown_has_no_error(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	mr := newRelayer(t, &fakeSocket{read: readMessages}, queue.NewPriorityMailbox(nil))
+
+	terminated := mr.Start(ctx)
+	cancel()
+	<-terminated
+
+	require.NoError(t, mr.Err())
+}
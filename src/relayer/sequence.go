@@ -0,0 +1,146 @@
+package relayer
+
+import (
+	"sync"
+
+	"github.com/mstreet3/message-relayer/domain"
+)
+
+// sequencer numbers the messages of each type in the order they are read.
+type sequencer struct {
+	mu   sync.Mutex
+	last map[domain.MessageType]uint64
+}
+
+func newSequencer() *sequencer {
+	return &sequencer{
+		last: make(map[domain.MessageType]uint64),
+	}
+}
+
+// seed carries on the numbering of each type from last, so that the
+// messages of a restarted relayer are numbered after the ones it replays.
+func (s *sequencer) seed(last map[domain.MessageType]uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for mt, seq := range last {
+		if seq > s.last[mt] {
+			s.last[mt] = seq
+		}
+	}
+}
+
+// sequenceSource is a mailbox that keeps the messages of an earlier
+// relayer, and knows the highest sequence number of each type among them.
+type sequenceSource interface {
+	Sequences() map[domain.MessageType]uint64
+}
+
+// next returns the sequence number of the next message of type mt.
+func (s *sequencer) next(mt domain.MessageType) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.last[mt]++
+	return s.last[mt]
+}
+
+// gapWindow is how far below the highest sequence number of a type a
+// message may arrive and still fill the gap it left.
+const gapWindow = 1024
+
+// gaps is what a GapDetector knows of the messages of a single type.
+type gaps struct {
+	high    uint64
+	missing map[uint64]struct{}
+}
+
+// forget stops waiting for the missed messages that are too old to arrive.
+func (g *gaps) forget() {
+	if len(g.missing) <= 2*gapWindow {
+		return
+	}
+	for seq := range g.missing {
+		if seq+gapWindow < g.high {
+			delete(g.missing, seq)
+		}
+	}
+}
+
+// GapDetector tells a subscriber how many messages it missed, from the
+// sequence numbers of the messages it receives.  a subscription that drops
+// messages, or a mailbox that evicts them, leaves a gap.  messages may
+// arrive out of order, as a LIFO mailbox empties them newest first: a
+// message that arrives after a later one fills the gap it left.  it only
+// counts the messages of the types a subscriber receives every message of,
+// so a subscription whose predicate skips messages of a type sees false
+// gaps.
+type GapDetector struct {
+	mu     sync.Mutex
+	types  map[domain.MessageType]*gaps
+	missed uint64
+}
+
+func NewGapDetector() *GapDetector {
+	return &GapDetector{
+		types: make(map[domain.MessageType]*gaps),
+	}
+}
+
+// Observe records msg and returns the number of messages of its type that
+// were skipped between the highest sequence number observed so far and
+// msg's.  the first message of a type, a message without a sequence
+// number, a duplicate and a message that arrives late miss nothing.
+func (g *GapDetector) Observe(msg domain.Message) uint64 {
+	if msg.Sequence == 0 {
+		return 0
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var (
+		mt     = msg.Type()
+		seq    = msg.Sequence
+		gt, ok = g.types[mt]
+	)
+
+	if !ok {
+		g.types[mt] = &gaps{high: seq, missing: make(map[uint64]struct{})}
+		return 0
+	}
+
+	if seq <= gt.high {
+		if _, ok := gt.missing[seq]; ok {
+			delete(gt.missing, seq)
+			g.missed--
+		}
+		return 0
+	}
+
+	// only the messages that may still arrive are waited for
+	from := gt.high + 1
+	if seq-from > gapWindow {
+		from = seq - gapWindow
+	}
+	for n := from; n < seq; n++ {
+		gt.missing[n] = struct{}{}
+	}
+
+	missed := seq - gt.high - 1
+	gt.high = seq
+	gt.forget()
+
+	g.missed += missed
+	return missed
+}
+
+// Missed returns the number of messages missed across every type, less
+// the ones that arrived late.
+func (g *GapDetector) Missed() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.missed
+}
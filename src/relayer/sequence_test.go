@@ -0,0 +1,259 @@
+package relayer
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mstreet3/message-relayer/codec"
+	"github.com/mstreet3/message-relayer/domain"
+	queue "github.com/mstreet3/message-relayer/mailbox"
+	"github.com/mstreet3/message-relayer/network"
+	lifo "github.com/mstreet3/message-relayer/queues/lifoqueue"
+	"github.com/stretchr/testify/require"
+)
+
+func sequenced(mt domain.MessageType, seq uint64) domain.Message {
+	msg := domain.NewMessage(mt, nil)
+	msg.Sequence = seq
+	return msg
+}
+
+func Test_sequencer_counts_each_type(t *testing.T) {
+	s := newSequencer()
+
+	require.Equal(t, uint64(1), s.next(domain.StartNewRound))
+	require.Equal(t, uint64(2), s.next(domain.StartNewRound))
+	require.Equal(t, uint64(1), s.next(domain.ReceivedAnswer))
+	require.Equal(t, uint64(3), s.next(domain.StartNewRound))
+}
+
+func Test_sequencer_carries_on_from_a_seed(t *testing.T) {
+	s := newSequencer()
+	s.next(domain.ReceivedAnswer)
+	s.next(domain.ReceivedAnswer)
+
+	s.seed(map[domain.MessageType]uint64{
+		domain.StartNewRound:  7,
+		domain.ReceivedAnswer: 1,
+	})
+
+	require.Equal(t, uint64(8), s.next(domain.StartNewRound))
+	require.Equal(t, uint64(3), s.next(domain.ReceivedAnswer))
+}
+
+func Test_GapDetector_counts_missed_messages(t *testing.T) {
+	g := NewGapDetector()
+
+	// a subscriber that joins late misses nothing on its first message
+	require.Equal(t, uint64(0), g.Observe(sequenced(domain.StartNewRound, 5)))
+	require.Equal(t, uint64(0), g.Observe(sequenced(domain.StartNewRound, 6)))
+	require.Equal(t, uint64(2), g.Observe(sequenced(domain.StartNewRound, 9)))
+
+	// types are counted apart
+	require.Equal(t, uint64(0), g.Observe(sequenced(domain.ReceivedAnswer, 1)))
+	require.Equal(t, uint64(1), g.Observe(sequenced(domain.ReceivedAnswer, 3)))
+
+	require.Equal(t, uint64(3), g.Missed())
+}
+
+func Test_GapDetector_ignores_duplicates_and_unsequenced_messages(t *testing.T) {
+	g := NewGapDetector()
+
+	require.Equal(t, uint64(0), g.Observe(sequenced(domain.StartNewRound, 1)))
+	require.Equal(t, uint64(0), g.Observe(sequenced(domain.StartNewRound, 1)))
+	require.Equal(t, uint64(0), g.Observe(domain.NewMessage(domain.StartNewRound, nil)))
+	require.Equal(t, uint64(0), g.Observe(sequenced(domain.StartNewRound, 2)))
+	require.Equal(t, uint64(0), g.Missed())
+}
+
+func Test_GapDetector_keeps_counting_past_a_message_too_late_to_fill_a_gap(t *testing.T) {
+	g := NewGapDetector()
+
+	g.Observe(sequenced(domain.StartNewRound, 2*gapWindow))
+	require.Equal(t, uint64(0), g.Observe(sequenced(domain.StartNewRound, 1)))
+	require.Equal(t, uint64(1), g.Observe(sequenced(domain.StartNewRound, 2*gapWindow+2)))
+	require.Equal(t, uint64(1), g.Missed())
+}
+
+func Test_GapDetector_counts_late_messages_through_a_LIFO_queue(t *testing.T) {
+	var (
+		g     = NewGapDetector()
+		stack = lifo.NewLIFOQueue[domain.Message]()
+	)
+
+	// the default mailbox empties every batch newest first, and 6 is lost
+	for _, batch := range [][]uint64{{1, 2, 3}, {4, 5}, {7, 8}} {
+		for _, seq := range batch {
+			stack.PushFront(sequenced(domain.StartNewRound, seq))
+		}
+		for _, msg := range stack.Empty() {
+			g.Observe(msg)
+		}
+	}
+
+	require.Equal(t, uint64(1), g.Missed())
+}
+
+func Test_MessageRelayer_numbers_messages_at_ingress(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		mb          = queue.NewPriorityMailbox([]queue.PriorityRule{
+			{Type: domain.StartNewRound, Retention: domain.KeepAll()},
+			{Type: domain.ReceivedAnswer, Retention: domain.KeepAll()},
+		})
+		reads atomic.Int64
+		// every third message is an answer
+		fake = &fakeSocket{read: func() (*domain.Message, error) {
+			mt := domain.StartNewRound
+			if reads.Add(1)%3 == 0 {
+				mt = domain.ReceivedAnswer
+			}
+			msg := domain.NewMessage(mt, nil)
+			return &msg, nil
+		}}
+		mr = newRelayer(t, fake, mb, WithReadInterval(time.Millisecond))
+	)
+	defer cancel()
+
+	msgs, _ := mr.SubscribeAll(context.Background(), WithBlockingDelivery(0))
+	terminated := mr.Start(ctx)
+
+	var (
+		ids  = make(map[string]struct{})
+		last = make(map[domain.MessageType]uint64)
+		g    = NewGapDetector()
+	)
+	for len(ids) < 30 {
+		msg := <-msgs
+		require.NotEmpty(t, msg.ID)
+		ids[msg.ID] = struct{}{}
+
+		// every type is numbered from 1 without gaps
+		require.Equal(t, last[msg.Type()]+1, msg.Sequence)
+		last[msg.Type()] = msg.Sequence
+		require.Equal(t, uint64(0), g.Observe(msg))
+	}
+
+	cancel()
+	<-terminated
+}
+
+func Test_MessageRelayer_keeps_ids_read_from_network(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		msg         = domain.NewMessage(heartbeat, nil)
+	)
+	defer cancel()
+
+	msg.ID = "upstream"
+	mr := newRelayer(t, network.NewNetworkSocketStub([]network.NetworkResponse{{Message: &msg}}), queue.NewPriorityMailbox(nil))
+
+	hbCh, _ := mr.Subscribe(context.Background(), heartbeat, WithBufferedDelivery(1))
+	terminated := mr.Start(ctx)
+
+	got := <-hbCh
+	require.Equal(t, "upstream", got.ID)
+	require.Equal(t, uint64(1), got.Sequence)
+
+	cancel()
+	<-terminated
+}
+
+func Test_MessageRelayer_fits_long_ids_read_from_network(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		msg         = domain.NewMessage(heartbeat, nil)
+		id          = strings.Repeat("x", codec.MaxIDSize+1)
+	)
+	defer cancel()
+
+	mb, err := queue.OpenWALMailbox(queue.WALConfig{Dir: t.TempDir()})
+	require.NoError(t, err)
+	defer mb.Close()
+
+	msg.ID = id
+	mr := newRelayer(t, network.NewNetworkSocketStub([]network.NetworkResponse{{Message: &msg}}), mb)
+
+	hbCh, _ := mr.Subscribe(context.Background(), heartbeat, WithBufferedDelivery(1))
+	terminated := mr.Start(ctx)
+
+	// the message is written to the log under the hash of its id
+	got := <-hbCh
+	require.Equal(t, hashID(id), got.ID)
+	require.LessOrEqual(t, len(got.ID), codec.MaxIDSize)
+	require.NoError(t, mb.Err())
+
+	cancel()
+	<-terminated
+}
+
+func Test_MessageRelayer_stamps_a_copy_of_each_message_read(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		// the reader hands out the same message on every read
+		read = domain.NewMessage(domain.StartNewRound, nil)
+		fake = &fakeSocket{read: func() (*domain.Message, error) {
+			return &read, nil
+		}}
+		mb = queue.NewPriorityMailbox([]queue.PriorityRule{
+			{Type: domain.StartNewRound, Retention: domain.KeepAll()},
+		})
+		mr = newRelayer(t, fake, mb, WithReadInterval(time.Millisecond))
+	)
+	defer cancel()
+
+	msgs, _ := mr.Subscribe(context.Background(), domain.StartNewRound, WithBlockingDelivery(0))
+	terminated := mr.Start(ctx)
+
+	first, second := <-msgs, <-msgs
+	require.NotEqual(t, first.ID, second.ID)
+	require.Equal(t, first.Sequence+1, second.Sequence)
+
+	cancel()
+	<-terminated
+
+	require.Empty(t, read.ID)
+	require.Zero(t, read.Sequence)
+}
+
+func Test_MessageRelayer_numbers_messages_after_the_ones_it_replays(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		msgs = make([]network.NetworkResponse, 3)
+	)
+	for i := range msgs {
+		msg := domain.NewMessage(domain.StartNewRound, nil)
+		msgs[i] = network.NetworkResponse{Message: &msg}
+	}
+
+	relay := func(n int) []uint64 {
+		mb, err := queue.OpenWALMailbox(queue.WALConfig{Dir: dir})
+		require.NoError(t, err)
+		defer mb.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		mr := newRelayer(t, network.NewNetworkSocketStub(msgs), mb)
+		rounds, _ := mr.Subscribe(context.Background(), domain.StartNewRound, WithBufferedDelivery(2*len(msgs)))
+		terminated := mr.Start(ctx)
+
+		seqs := make([]uint64, 0, n)
+		for len(seqs) < n {
+			seqs = append(seqs, (<-rounds).Sequence)
+		}
+
+		cancel()
+		<-terminated
+		return seqs
+	}
+
+	require.Equal(t, []uint64{1, 2, 3}, relay(3))
+
+	// the second relayer replays nothing, as every message was taken, but
+	// still numbers its own after the ones in the log
+	require.Equal(t, []uint64{4, 5, 6}, relay(3))
+}
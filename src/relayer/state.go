@@ -0,0 +1,133 @@
+package relayer
+
+import "sync"
+
+// State is a stage in the lifecycle of a relayer.
+type State int
+
+const (
+	Created    State = iota // created, not yet started
+	Starting                // started, bringing up its loops
+	Running                 // relaying messages
+	Restarting              // restarting the network
+	Stopping                // shutting down
+	Stopped                 // shut down, Err reports why
+)
+
+func (s State) String() string {
+	switch s {
+	case Created:
+		return "Created"
+	case Starting:
+		return "Starting"
+	case Running:
+		return "Running"
+	case Restarting:
+		return "Restarting"
+	case Stopping:
+		return "Stopping"
+	case Stopped:
+		return "Stopped"
+	default:
+		return "Unknown"
+	}
+}
+
+// StateChange is a transition of a relayer's lifecycle.  Err is the
+// termination error on the transition to Stopped.
+type StateChange struct {
+	From State
+	To   State
+	Err  error
+}
+
+// stateChangeBuffer is the number of transitions a watcher may fall behind
+// before the oldest undelivered transition is dropped.
+const stateChangeBuffer = 16
+
+// lifecycle tracks the state of a relayer and delivers its transitions to
+// watchers.  a watcher never blocks a transition: once its buffer is full
+// the oldest transition is dropped, so the latest ones are always
+// delivered.  watcher channels are closed after the transition to Stopped.
+type lifecycle struct {
+	mu       sync.Mutex
+	state    State
+	watchers []chan StateChange
+}
+
+func (l *lifecycle) get() State {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.state
+}
+
+func (l *lifecycle) set(to State, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.transition(to, err)
+}
+
+// start moves a created lifecycle to Starting and reports whether it did,
+// so that a relayer is started once.
+func (l *lifecycle) start() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.state != Created {
+		return false
+	}
+
+	l.transition(Starting, nil)
+	return true
+}
+
+// transition moves to state to and delivers the change.  the caller must
+// hold the lock.
+func (l *lifecycle) transition(to State, err error) {
+	if l.state == to || l.state == Stopped {
+		return
+	}
+
+	change := StateChange{From: l.state, To: to, Err: err}
+	l.state = to
+
+	for _, w := range l.watchers {
+		select {
+		case w <- change:
+		default:
+			// drop the oldest transition, unless the watcher took it
+			// meanwhile, and never wait for the watcher
+			select {
+			case <-w:
+			default:
+			}
+			select {
+			case w <- change:
+			default:
+			}
+		}
+		if to == Stopped {
+			close(w)
+		}
+	}
+
+	if to == Stopped {
+		l.watchers = nil
+	}
+}
+
+func (l *lifecycle) watch() <-chan StateChange {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w := make(chan StateChange, stateChangeBuffer)
+	if l.state == Stopped {
+		close(w)
+		return w
+	}
+
+	l.watchers = append(l.watchers, w)
+	return w
+}
@@ -0,0 +1,128 @@
+package relayer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mstreet3/message-relayer/domain"
+	queue "github.com/mstreet3/message-relayer/mailbox"
+	"github.com/mstreet3/message-relayer/network"
+	"github.com/stretchr/testify/require"
+)
+
+func collectStates(ch <-chan StateChange) <-chan []StateChange {
+	collected := make(chan []StateChange, 1)
+	go func() {
+		changes := make([]StateChange, 0)
+		for c := range ch {
+			changes = append(changes, c)
+		}
+		collected <- changes
+	}()
+	return collected
+}
+
+func Test_MessageRelayer_clean_shutdown_lifecycle(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		mr          = newRelayer(t, &fakeSocket{read: readMessages}, queue.NewPriorityMailbox(nil))
+		states      = collectStates(mr.States())
+	)
+
+	require.Equal(t, Created, mr.State())
+
+	terminated := mr.Start(ctx)
+	require.Equal(t, Running, mr.State())
+
+	// starting again does not start a second set of loops
+	require.Equal(t, terminated, mr.Start(ctx))
+
+	cancel()
+	require.NoError(t, mr.Wait())
+	require.Equal(t, Stopped, mr.State())
+
+	require.Equal(t, []StateChange{
+		{From: Created, To: Starting},
+		{From: Starting, To: Running},
+		{From: Running, To: Stopping},
+		{From: Stopping, To: Stopped},
+	}, <-states)
+}
+
+func Test_MessageRelayer_restart_lifecycle(t *testing.T) {
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		msg         = domain.NewMessage(domain.StartNewRound, nil)
+		socket      = network.NewNetworkSocketStub([]network.NetworkResponse{{Message: &msg}})
+		mr          = newRelayer(t, socket, queue.NewPriorityMailbox(nil),
+			WithReadInterval(5*time.Millisecond),
+		)
+		states = mr.States()
+	)
+	defer cancel()
+
+	mr.Start(ctx)
+
+	// the stub runs dry after every message and is restarted
+	want := []State{Starting, Running, Restarting, Running, Restarting, Running}
+	for _, to := range want {
+		select {
+		case c := <-states:
+			require.Equal(t, to, c.To)
+		case <-ctx.Done():
+			t.Fatalf("no transition to %s", to)
+		}
+	}
+
+	cancel()
+	require.NoError(t, mr.Wait())
+}
+
+func Test_MessageRelayer_fatal_lifecycle(t *testing.T) {
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		errStop     = errors.New("stop")
+		mr          = newRelayer(t, &fakeSocket{read: readErrors(errStop)}, queue.NewPriorityMailbox(nil),
+			WithReadInterval(5*time.Millisecond),
+			WithErrorHandler(func(error) ErrorAction { return StopRelayer }),
+		)
+		states = collectStates(mr.States())
+	)
+	defer cancel()
+
+	mr.Start(ctx)
+
+	require.ErrorIs(t, mr.Wait(), errStop)
+
+	changes := <-states
+	last := changes[len(changes)-1]
+	require.Equal(t, Stopped, last.To)
+	require.ErrorIs(t, last.Err, errStop)
+
+	// watching a stopped relayer yields no transitions
+	_, open := <-mr.States()
+	require.False(t, open)
+}
+
+func Test_lifecycle_slow_watcher_gets_latest_transitions(t *testing.T) {
+	var (
+		l = lifecycle{}
+		w = l.watch()
+	)
+
+	for i := 0; i < stateChangeBuffer*2; i++ {
+		l.set(Restarting, nil)
+		l.set(Running, nil)
+	}
+	l.set(Stopped, nil)
+
+	changes := make([]StateChange, 0)
+	for c := range w {
+		changes = append(changes, c)
+	}
+
+	require.Len(t, changes, stateChangeBuffer)
+	require.Equal(t, StateChange{From: Running, To: Stopped}, changes[len(changes)-1])
+}
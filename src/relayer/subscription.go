@@ -0,0 +1,460 @@
+package relayer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mstreet3/message-relayer/domain"
+	"github.com/mstreet3/message-relayer/utils"
+)
+
+// DeliveryMode is how a message is delivered to a subscriber that is not
+// ready to receive it.  every subscription queues at most one message
+// ahead of the subscriber, or the depth of a Buffered subscription, and
+// the mode decides what happens to a message notified while the queue is
+// full as well.
+type DeliveryMode int
+
+const (
+	// DropWhenBusy skips a subscriber that cannot receive immediately.
+	DropWhenBusy DeliveryMode = iota
+	// BlockWithTimeout waits for the subscriber up to a timeout that starts
+	// when the message is notified.  Notify waits while the queue is full.
+	BlockWithTimeout
+	// Buffered queues messages for the subscriber up to a depth and drops
+	// messages once the buffer is full.
+	Buffered
+)
+
+func (m DeliveryMode) String() string {
+	switch m {
+	case DropWhenBusy:
+		return "DropWhenBusy"
+	case BlockWithTimeout:
+		return "BlockWithTimeout"
+	case Buffered:
+		return "Buffered"
+	default:
+		return "Unknown"
+	}
+}
+
+// SubscriptionScope is which messages a subscription observes.
+type SubscriptionScope int
+
+const (
+	// Typed subscriptions observe a single message type.
+	Typed SubscriptionScope = iota
+	// Filtered subscriptions observe the messages that match a predicate.
+	Filtered
+	// Wildcard subscriptions observe every message.
+	Wildcard
+)
+
+func (s SubscriptionScope) String() string {
+	switch s {
+	case Typed:
+		return "Typed"
+	case Filtered:
+		return "Filtered"
+	case Wildcard:
+		return "Wildcard"
+	default:
+		return "Unknown"
+	}
+}
+
+type subscribeConfig struct {
+	mode    DeliveryMode
+	timeout time.Duration
+	depth   int
+	onDrop  func(domain.Message)
+	replay  int
+}
+
+// queueSize is the most live messages queued for the subscriber.  the
+// replayed messages are queued past it, so the replayed batch does not
+// size the queue for the rest of the subscription.
+func (cfg subscribeConfig) queueSize() int {
+	if cfg.mode == Buffered {
+		return cfg.depth
+	}
+	return 1
+}
+
+// SubscribeOption configures a single subscription.
+type SubscribeOption func(*subscribeConfig)
+
+func newSubscribeConfig(opts []SubscribeOption) subscribeConfig {
+	cfg := subscribeConfig{mode: DropWhenBusy}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithDropDelivery drops messages that the subscriber is too busy to
+// receive.  this is the default.
+func WithDropDelivery() SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.mode = DropWhenBusy
+		cfg.depth = 0
+	}
+}
+
+// WithBlockingDelivery waits up to timeout for the subscriber to receive
+// each message.  a timeout of zero waits until the subscription ends.
+func WithBlockingDelivery(timeout time.Duration) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.mode = BlockWithTimeout
+		cfg.timeout = timeout
+		cfg.depth = 0
+	}
+}
+
+// WithBufferedDelivery queues up to depth messages for the subscriber.
+// a depth below one is treated as one.
+func WithBufferedDelivery(depth int) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		if depth < 1 {
+			depth = 1
+		}
+		cfg.mode = Buffered
+		cfg.depth = depth
+	}
+}
+
+// WithOnDrop calls f with every message dropped for the subscriber.  f runs
+// on the delivering goroutine and must not block.
+func WithOnDrop(f func(domain.Message)) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.onDrop = f
+	}
+}
+
+// WithReplay delivers up to the last n messages the subscription observes
+// from the manager's replay buffers before any live message.  replayed
+// messages are waited for whatever the delivery mode, so none of them are
+// dropped; live messages are delivered in the subscription's mode.
+func WithReplay(n int) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.replay = n
+	}
+}
+
+// SubscriptionStats counts what happened to the messages notified to a
+// single subscription.  a message is stopped when the subscription ended
+// while it was being delivered.  Type is only set for Typed subscriptions.
+type SubscriptionStats struct {
+	ID        uuid.UUID
+	Scope     SubscriptionScope
+	Type      domain.MessageType
+	Mode      DeliveryMode
+	Delivered uint64
+	Dropped   uint64
+	Stopped   uint64
+}
+
+// delivery is a message queued for a subscriber and the time a blocking
+// delivery gives up on it, which is zero to wait until the subscription
+// ends.  a replayed delivery is waited for in any delivery mode.
+type delivery struct {
+	msg      domain.Message
+	deadline time.Time
+	replayed bool
+}
+
+// blocks reports whether d is waited for rather than dropped while the
+// subscriber is busy.
+func (d delivery) blocks(mode DeliveryMode) bool {
+	return d.replayed || mode == BlockWithTimeout
+}
+
+// subscription is registered with the manager along with its configuration
+// and delivery counters.  a Typed subscription observes messages of type mt
+// and a Filtered one the messages that match filter.
+type subscription struct {
+	id        uuid.UUID
+	scope     SubscriptionScope
+	mt        domain.MessageType
+	filter    MessagePredicate
+	cfg       subscribeConfig
+	queue     *deliveryQueue[delivery]
+	msgCh     chan domain.Message
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	onClose   func()
+	onFinish  func()
+	dispatch  atomic.Int32
+	finished  atomic.Bool
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	stopped   atomic.Uint64
+}
+
+func newSubscription(id uuid.UUID, scope SubscriptionScope, mt domain.MessageType, filter MessagePredicate, cfg subscribeConfig) *subscription {
+	return &subscription{
+		id:      id,
+		scope:   scope,
+		mt:      mt,
+		filter:  filter,
+		cfg:     cfg,
+		queue:   newDeliveryQueue[delivery](cfg.queueSize()),
+		msgCh:   make(chan domain.Message, cfg.depth),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// record counts the result of delivering msg.
+func (s *subscription) record(res deliveryResult, msg domain.Message) {
+	switch res {
+	case delivered:
+		s.delivered.Add(1)
+	case dropped:
+		s.dropped.Add(1)
+		if s.cfg.onDrop != nil {
+			s.cfg.onDrop(msg)
+		}
+	case stopped:
+		s.stopped.Add(1)
+	}
+}
+
+// enqueue queues msg for the subscriber's worker and reports whether it
+// did.  while the queue is full a blocking subscription waits for room up
+// to its timeout, or until ctx is done, and any other subscription drops
+// msg.
+func (s *subscription) enqueue(ctx context.Context, msg domain.Message) bool {
+	var (
+		d       = delivery{msg: msg}
+		timeout <-chan time.Time
+	)
+
+	if s.cfg.mode == BlockWithTimeout && s.cfg.timeout > 0 {
+		t := time.NewTimer(s.cfg.timeout)
+		defer t.Stop()
+		timeout = t.C
+		d.deadline = time.Now().Add(s.cfg.timeout)
+	}
+
+	for {
+		switch s.queue.push(d) {
+		case pushed:
+			return true
+		case queueClosed:
+			s.record(stopped, msg)
+			return false
+		}
+
+		if s.cfg.mode != BlockWithTimeout {
+			s.record(dropped, msg)
+			return false
+		}
+
+		select {
+		case <-ctx.Done():
+			s.record(stopped, msg)
+			return false
+		case <-s.closing:
+			s.record(stopped, msg)
+			return false
+		case <-timeout:
+			s.record(dropped, msg)
+			return false
+		case <-s.queue.hasSpace():
+		}
+	}
+}
+
+// replay queues a replayed msg past the queue's limit, so it never waits
+// and is never dropped.
+func (s *subscription) replay(msg domain.Message) {
+	d := delivery{msg: msg, replayed: true}
+	if s.cfg.mode == BlockWithTimeout && s.cfg.timeout > 0 {
+		d.deadline = time.Now().Add(s.cfg.timeout)
+	}
+
+	if s.queue.pushOver(d) != pushed {
+		s.record(stopped, msg)
+	}
+}
+
+// observe delivers a queued message and counts the result.
+func (s *subscription) observe(d delivery) {
+	s.report(s.cfg.deliver(s.closing, s.msgCh, d), d.msg)
+}
+
+func (s *subscription) report(res deliveryResult, msg domain.Message) {
+	s.record(res, msg)
+
+	switch res {
+	case delivered:
+		utils.DPrintf("%s: received message of type %s", s.id, msg.Type())
+	case dropped:
+		utils.DPrintf("%s: dropped message of type %s", s.id, msg.Type())
+	case stopped:
+		utils.DPrintf("%s: received stop signal", s.id)
+	}
+}
+
+// matches reports whether the subscription observes msg.
+func (s *subscription) matches(msg domain.Message) bool {
+	switch s.scope {
+	case Typed:
+		return msg.Type() == s.mt
+	case Filtered:
+		return s.filter(msg)
+	default:
+		return true
+	}
+}
+
+// deliverNext delivers the oldest queued message and reports whether there
+// was one.  a blocking delivery to a subscriber that is not ready is not
+// waited on but returned, for the caller to observe.  once the
+// subscription is closing it is finished instead.  it must only be called
+// by the worker that owns the subscription.
+func (s *subscription) deliverNext() (waiting *delivery, ok bool) {
+	if s.finished.Load() {
+		s.stopQueued()
+		return nil, false
+	}
+
+	if s.isClosing() {
+		s.finish()
+		return nil, false
+	}
+
+	d, ok := s.queue.tryPop()
+	if !ok {
+		return nil, false
+	}
+
+	if !d.blocks(s.cfg.mode) {
+		s.observe(d)
+		return nil, true
+	}
+
+	select {
+	case s.msgCh <- d.msg:
+		s.report(delivered, d.msg)
+		return nil, true
+	default:
+		return &d, true
+	}
+}
+
+// pending reports whether the subscription has messages to deliver or is
+// closing and not yet finished.
+func (s *subscription) pending() bool {
+	return s.queue.len() > 0 || (s.isClosing() && !s.finished.Load())
+}
+
+func (s *subscription) isClosing() bool {
+	select {
+	case <-s.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops delivering to the subscription.  its worker finishes it.
+// close is safe to call more than once.
+func (s *subscription) close() {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+		if s.onClose != nil {
+			s.onClose()
+		}
+	})
+}
+
+// finish counts the queued messages as stopped, refuses any later message
+// and closes msgCh.  it must only be called once by the worker that owns
+// the subscription.
+func (s *subscription) finish() {
+	if s.onFinish != nil {
+		defer s.onFinish()
+	}
+	defer close(s.done)
+	defer close(s.msgCh)
+
+	s.finished.Store(true)
+	s.stopQueued()
+}
+
+func (s *subscription) stopQueued() {
+	for _, d := range s.queue.close() {
+		s.record(stopped, d.msg)
+	}
+}
+
+func (s *subscription) stats() SubscriptionStats {
+	return SubscriptionStats{
+		ID:        s.id,
+		Type:      s.mt,
+		Scope:     s.scope,
+		Mode:      s.cfg.mode,
+		Delivered: s.delivered.Load(),
+		Dropped:   s.dropped.Load(),
+		Stopped:   s.stopped.Load(),
+	}
+}
+
+type deliveryResult int
+
+const (
+	delivered deliveryResult = iota
+	dropped
+	stopped
+)
+
+// deliver sends d's message on msgCh as the delivery mode dictates, giving
+// up as soon as closing is closed.  a blocking delivery waits until d's
+// deadline.
+func (cfg subscribeConfig) deliver(closing <-chan struct{}, msgCh chan<- domain.Message, d delivery) deliveryResult {
+	// closing and a ready msgCh may both be selectable
+	select {
+	case <-closing:
+		return stopped
+	default:
+	}
+
+	select {
+	case <-closing:
+		return stopped
+	case msgCh <- d.msg:
+		return delivered
+	default:
+	}
+
+	if !d.blocks(cfg.mode) {
+		return dropped
+	}
+
+	var timeout <-chan time.Time
+	if !d.deadline.IsZero() {
+		wait := time.Until(d.deadline)
+		if wait <= 0 {
+			return dropped
+		}
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case <-closing:
+		return stopped
+	case msgCh <- d.msg:
+		return delivered
+	case <-timeout:
+		return dropped
+	}
+}